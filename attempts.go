@@ -0,0 +1,78 @@
+package pqueue
+
+import (
+	"context"
+	"time"
+)
+
+// Outcomes of an attempt.
+const (
+	AttemptCompleted    = "completed"
+	AttemptFailed       = "failed"
+	AttemptCancelled    = "cancelled"
+	AttemptLeaseExpired = "lease_expired"
+	AttemptSnoozed      = "snoozed" // does not count in the run count
+)
+
+// Attempt describes a run of a job.
+type Attempt struct {
+	JobID      int64     `json:"job_id"`
+	Attempt    uint      `json:"attempt"` // run count after the run
+	WorkerID   string    `json:"worker_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   float64   `json:"duration"` // second
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error"`
+}
+
+// attemptSQL inserts an attempt of the job updated by the CTE named updated, which returns
+// id and run_count. worker, started, elapsed and errStr are bind parameters. The attempt finishes
+// elapsed seconds after started, the start time of the run, or now without it.
+func attemptSQL(outcome, worker, started, elapsed, errStr string) string {
+	return `INSERT INTO {attempts} (job_id, attempt, worker_id, started_at, finished_at, duration, outcome, error) SELECT id, run_count, NULLIF(` + worker + `, ''), ` +
+		`coalesce(` + started + `::timestamptz, now() - make_interval(secs => ` + elapsed + `::float8)), coalesce(` + started + `::timestamptz + make_interval(secs => ` + elapsed + `::float8), now()), ` +
+		elapsed + `, '` + outcome + `', ` + errStr + ` FROM updated`
+}
+
+// startedAt returns the start time of the run of a job as a bind parameter of attemptSQL.
+func startedAt(j *Job) interface{} {
+	if j.startedAt.IsZero() {
+		return nil
+	}
+	return j.startedAt
+}
+
+// JobAttempts returns the attempts of a job in order.
+func (c *Client) JobAttempts(ctx context.Context, id int64) ([]Attempt, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`SELECT job_id, attempt, coalesce(worker_id, ''), started_at, finished_at, duration, outcome, error FROM {attempts} WHERE job_id = $1 ORDER BY id`), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []Attempt
+	for rows.Next() {
+		a := Attempt{}
+		err := rows.Scan(
+			&a.JobID,
+			&a.Attempt,
+			&a.WorkerID,
+			&a.StartedAt,
+			&a.FinishedAt,
+			&a.Duration,
+			&a.Outcome,
+			&a.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// JobAttempts returns the attempts of a job using the default client.
+func JobAttempts(ctx context.Context, id int64) ([]Attempt, error) {
+	return defaultClient.JobAttempts(ctx, id)
+}
@@ -0,0 +1,49 @@
+package pqueue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestJobAttempts(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	c := NewClient(DefaultClient().DB(), WithRetryPolicy(FixedBackoff(0)))
+	j := NewJob("test", nil, 5)
+	c.Save(ctx, &j)
+
+	jobs, _ := c.LockJobs(ctx, 1)
+	if len(jobs) != 1 {
+		t.Fatalf("locked jobs expect 1, actual %d", len(jobs))
+	}
+	jobs[0].Elapsed = 1.5
+	if err := c.Fail(ctx, &jobs[0], "boom"); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ = c.LockJobs(ctx, 1)
+	if len(jobs) != 1 {
+		t.Fatalf("retried jobs expect 1, actual %d", len(jobs))
+	}
+	if err := c.Complete(ctx, &jobs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := JobAttempts(ctx, j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("attempts expect 2, actual %d", len(attempts))
+	}
+	first := attempts[0]
+	if first.Attempt != 1 || first.Outcome != AttemptFailed || first.Error != "boom" || first.WorkerID != c.lockID {
+		t.Errorf("invalid first attempt %+v", first)
+	}
+	if first.Duration != 1.5 || first.FinishedAt.Sub(first.StartedAt).Seconds() < 1.4 {
+		t.Errorf("first attempt should take 1.5 seconds, %+v", first)
+	}
+	if attempts[1].Attempt != 2 || attempts[1].Outcome != AttemptCompleted || attempts[1].Error != "" {
+		t.Errorf("invalid second attempt %+v", attempts[1])
+	}
+}
@@ -21,14 +21,7 @@ type payloadJSON struct {
 	Duration int `json:"duration"`
 }
 
-type worker struct{}
-
-func (w worker) Run(ctx context.Context, job pqueue.Job) error {
-	var p payloadJSON
-	err := json.Unmarshal(job.Payload, &p)
-	if err != nil {
-		return err
-	}
+func sleep(ctx context.Context, p payloadJSON, meta pqueue.JobMeta) error {
 	var current time.Duration
 	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
@@ -61,6 +54,10 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = pqueue.Migrate(context.Background(), pqueue.DefaultClient().DB())
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	http.HandleFunc("/job", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -89,9 +86,14 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 	})
 	http.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
-		for i := 0; i < 50; i++ {
-			job := pqueue.NewJob("sleep", []byte(fmt.Sprintf(`{"duration":%d}`, rand.Intn(50)+1)), 20)
-			job.Save()
+		jobs := make([]pqueue.Job, 50)
+		for i := range jobs {
+			jobs[i] = pqueue.NewJob("sleep", []byte(fmt.Sprintf(`{"duration":%d}`, rand.Intn(50)+1)), 20)
+		}
+		_, err := pqueue.EnqueueMany(r.Context(), jobs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		w.WriteHeader(http.StatusOK)
 	})
@@ -100,11 +102,8 @@ func main() {
 		Addr: ":8080",
 	}
 
-	err = pqueue.ReleaseJobs()
-	if err != nil {
-		log.Fatal(err)
-	}
-	w := worker{}
+	w := pqueue.NewMux()
+	pqueue.Handle(w, "sleep", sleep)
 	d1 := pqueue.NewDispatcher(6, w)
 	d1.Start(200)
 	d2 := pqueue.NewDispatcher(4, w)
@@ -0,0 +1,125 @@
+package pqueue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrJobCancelled is the cause of the context of a job cancelled while running.
+var ErrJobCancelled = errors.New("pqueue: job cancelled")
+
+// ErrNotCancellable is returned by Cancel for a job which is finished or does not exist.
+var ErrNotCancellable = errors.New("pqueue: job is not pending or running")
+
+// Cancel cancels a job. A pending job is cancelled at once. The context of a running job
+// is cancelled by its dispatcher on the next tick, and the job is recorded as cancelled.
+// A locked job which has not started yet is recorded as cancelled without running.
+func (c *Client) Cancel(ctx context.Context, id int64) error {
+	var running bool
+	err := c.db.QueryRowContext(ctx, c.stmt(`UPDATE {job} SET cancelled_at = now(), status = CASE WHEN locked_at IS NULL THEN 3 ELSE status END, last_error = CASE WHEN locked_at IS NULL THEN $2 ELSE last_error END WHERE id = $1 AND status = 0 RETURNING locked_at IS NOT NULL`), id, ErrJobCancelled.Error()).Scan(&running)
+	if err == sql.ErrNoRows {
+		return ErrNotCancellable
+	}
+	if err != nil {
+		return err
+	}
+
+	c.logger.Log(ctx, slog.LevelInfo, "Cancelled job", slog.Int64("job_id", id), slog.Bool("running", running))
+	return nil
+}
+
+// cancelled records a running job as cancelled.
+func (c *Client) cancelled(ctx context.Context, j *Job) error {
+	res, err := c.db.ExecContext(ctx, c.stmt(`WITH updated AS (UPDATE {job} SET status = 3, run_count = $2, elapsed = $3, last_error = $4, locked_at = null, locked_by = null WHERE id = $1`+heldBy("$5")+` RETURNING id, run_count) `+attemptSQL(AttemptCancelled, "$5", "$6", "$3", "$4")), j.ID, j.RunCount+1, j.Elapsed, ErrJobCancelled.Error(), j.LockedBy, startedAt(j))
+	if err = leaseHeld(res, err); err != nil {
+		return err
+	}
+	j.Status = StatusCancelled
+	j.RunCount++
+	j.LockedBy = ""
+	j.LastError = ErrJobCancelled.Error()
+
+	c.logger.Log(ctx, slog.LevelInfo, "Cancelled running job", c.jobAttrs(j)...)
+	return nil
+}
+
+// cancelRequested returns the ids of running jobs whose cancel was requested.
+func (c *Client) cancelRequested(ctx context.Context, ids []int64) ([]int64, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`SELECT id FROM {job} WHERE id = ANY($1) AND status = 0 AND cancelled_at IS NOT NULL`), pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cancelled []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		cancelled = append(cancelled, id)
+	}
+	return cancelled, rows.Err()
+}
+
+// runningJobs holds the cancel functions of jobs locked by a dispatcher. Jobs waiting in
+// the buffer have no cancel function until they start.
+type runningJobs struct {
+	mu      sync.Mutex
+	cancels map[int64]context.CancelCauseFunc
+}
+
+func newRunningJobs() *runningJobs {
+	return &runningJobs{cancels: make(map[int64]context.CancelCauseFunc)}
+}
+
+func (r *runningJobs) add(id int64, cancel context.CancelCauseFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancels[id] = cancel
+}
+
+func (r *runningJobs) remove(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, id)
+}
+
+func (r *runningJobs) ids() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]int64, 0, len(r.cancels))
+	for id := range r.cancels {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (r *runningJobs) cancel(id int64, cause error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel := r.cancels[id]; cancel != nil {
+		cancel(cause)
+	}
+}
+
+// Cancel cancels a job using the default client.
+func Cancel(ctx context.Context, id int64) error {
+	return defaultClient.Cancel(ctx, id)
+}
+
+// CancelledJobs returns cancelled jobs using the default client.
+func CancelledJobs(prevTime time.Time, prevID int64) ([]Job, error) {
+	return CancelledJobsContext(context.Background(), prevTime, prevID)
+}
+
+// CancelledJobsContext returns cancelled jobs using the default client.
+func CancelledJobsContext(ctx context.Context, prevTime time.Time, prevID int64) ([]Job, error) {
+	return defaultClient.CancelledJobs(ctx, prevTime, prevID)
+}
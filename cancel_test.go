@@ -0,0 +1,121 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunningJobsCancel(t *testing.T) {
+	r := newRunningJobs()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r.add(1, cancel)
+	r.cancel(2, ErrJobCancelled)
+	if ctx.Err() != nil {
+		t.Error("other jobs should not be cancelled")
+	}
+	r.cancel(1, ErrJobCancelled)
+	if !errors.Is(context.Cause(ctx), ErrJobCancelled) {
+		t.Errorf("expect cause ErrJobCancelled, actual %v", context.Cause(ctx))
+	}
+	r.remove(1)
+	if len(r.ids()) != 0 {
+		t.Error("removed jobs should not be running")
+	}
+}
+
+func TestCancelPendingJob(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	j := NewJob("test", nil, 5)
+	j.Save()
+	if err := Cancel(ctx, j.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 0 {
+		t.Error("cancelled jobs should not be locked")
+	}
+	jobs, _ = CancelledJobs(time.Time{}, 0)
+	if len(jobs) != 1 || jobs[0].Status != StatusCancelled {
+		t.Fatalf("expect 1 cancelled job, actual %v", jobs)
+	}
+
+	if err := Cancel(ctx, j.ID); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("expect ErrNotCancellable, actual %v", err)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 60)
+	j.Save()
+
+	w := blockingWorker{canceled: make(chan struct{}, 1)}
+	d := NewDispatcher(1, w)
+	d.Start(50)
+	time.Sleep(110 * time.Millisecond)
+
+	if err := Cancel(context.Background(), j.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("running job should be canceled by its dispatcher")
+	}
+
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := CancelledJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Fatalf("cancelled jobs expect 1, actual %d", len(jobs))
+	}
+	if jobs[0].LastError != ErrJobCancelled.Error() {
+		t.Errorf("expect last error %q, actual %q", ErrJobCancelled.Error(), jobs[0].LastError)
+	}
+	jobs, _ = FailedJobs(time.Time{}, 0)
+	if len(jobs) != 0 {
+		t.Error("cancelled jobs should not be failed")
+	}
+}
+
+func TestCancelBufferedJob(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 60)
+	j.Save()
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 1 {
+		t.Fatalf("locked jobs expect 1, actual %d", len(jobs))
+	}
+	if err := Cancel(context.Background(), j.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	w := blockingWorker{canceled: make(chan struct{}, 1)}
+	d := NewDispatcher(1, w)
+	d.Start(1000)
+	d.jobBuffer <- jobs[0]
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	select {
+	case <-w.canceled:
+		t.Error("job cancelled before it started should not run")
+	default:
+	}
+	jobs, _ = CancelledJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Fatalf("cancelled jobs expect 1, actual %d", len(jobs))
+	}
+}
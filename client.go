@@ -0,0 +1,622 @@
+package pqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Client performs queue operations on a database.
+type Client struct {
+	db           *sql.DB
+	dsn          string
+	schema       string
+	table        string
+	replacer     *strings.Replacer
+	codecs       map[string]Codec
+	defaultCodec string
+	jobCodecs    map[string]string
+	// retry policies of the client, of job names, and selected by jobs
+	retryPolicy      RetryPolicy
+	jobRetryPolicies map[string]RetryPolicy
+	retryPolicies    map[string]RetryPolicy
+	logger           Logger
+	payloadLogging   PayloadLogging
+	// lockID is stored in locked_by of jobs locked by LockJobs.
+	lockID string
+}
+
+// ClientOption configures a client.
+type ClientOption func(*Client)
+
+// WithLogger sets the logger of a client and its dispatchers. The default is slog.Default().
+func WithLogger(l Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithPayloadLogging sets how job payloads appear in logs. The default is LogPayload.
+func WithPayloadLogging(p PayloadLogging) ClientOption {
+	return func(c *Client) {
+		c.payloadLogging = p
+	}
+}
+
+// WithSchema sets the PostgreSQL schema of the job table. The default is the search_path.
+func WithSchema(schema string) ClientOption {
+	return func(c *Client) {
+		c.schema = schema
+	}
+}
+
+// WithTable sets the name of the job table. The default is job.
+func WithTable(table string) ClientOption {
+	return func(c *Client) {
+		c.table = table
+	}
+}
+
+// NewClient creates a client using an existing database handle.
+// The handle is shared with the caller, so the client never closes it.
+func NewClient(db *sql.DB, opts ...ClientOption) *Client {
+	c := &Client{
+		db:               db,
+		table:            "job",
+		codecs:           map[string]Codec{"json": JSONCodec{}},
+		defaultCodec:     "json",
+		jobCodecs:        make(map[string]string),
+		jobRetryPolicies: make(map[string]RetryPolicy),
+		retryPolicies:    make(map[string]RetryPolicy),
+		logger:           slog.Default(),
+		payloadLogging:   LogPayload,
+		lockID:           newLockID(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.replacer = strings.NewReplacer(
+		"{job}", c.qualify(c.table),
+		// for names derived from the table inside a quoted identifier, such as indexes
+		"{job_name}", strings.Replace(c.table, `"`, `""`, -1),
+		// payload of any codec as bytes
+		"{payload}", `coalesce(convert_to(payload::text, 'UTF8'), raw_payload) AS payload`,
+		"{workers}", c.qualify(workersTable),
+		"{attempts}", c.qualify(c.table+"_attempts"),
+	)
+	return c
+}
+
+// newLockID returns an identifier of a lock holder, unique across processes.
+func newLockID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// qualify quotes a name, and qualifies it with the schema of the client.
+func (c *Client) qualify(name string) string {
+	if c.schema == "" {
+		return pq.QuoteIdentifier(name)
+	}
+	return pq.QuoteIdentifier(c.schema) + "." + pq.QuoteIdentifier(name)
+}
+
+// stmt fills the job table into a query.
+func (c *Client) stmt(query string) string {
+	return c.replacer.Replace(query)
+}
+
+// Open opens a database by a data source name and returns a client.
+func Open(dsn string, opts ...ClientOption) (*Client, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(db, append([]ClientOption{WithDSN(dsn)}, opts...)...), nil
+}
+
+// DB returns the database handle of the client.
+func (c *Client) DB() *sql.DB {
+	return c.db
+}
+
+// Querier is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Save inserts a job.
+func (c *Client) Save(ctx context.Context, j *Job) error {
+	return c.SaveTx(ctx, c.db, j)
+}
+
+const insertJobSQL = `INSERT INTO {job} (name,queue,codec,payload,raw_payload,status,priority,run_after,timeout,run_count,retry_delay,unique_key,max_attempts,retry_policy,last_error) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,'')`
+
+// SaveTx inserts a job using q, typically the caller's *sql.Tx.
+// The job becomes visible to dispatchers only after the transaction commits.
+func (c *Client) SaveTx(ctx context.Context, q Querier, j *Job) error {
+	if err := c.prepareJob(j); err != nil {
+		return err
+	}
+
+	args := insertArgs(j)
+	var err error
+	if j.UniqueKey != "" {
+		err = c.saveUnique(ctx, q, j, args)
+	} else {
+		err = q.QueryRowContext(ctx, c.stmt(insertJobSQL+` RETURNING id`), args...).Scan(&j.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return c.notify(ctx, q, j.Queue)
+}
+
+// insertArgs returns the bind parameters of a job in insertJobSQL.
+func insertArgs(j *Job) []interface{} {
+	var uniqueKey, retryPolicy interface{}
+	if j.UniqueKey != "" {
+		uniqueKey = j.UniqueKey
+	}
+	if j.RetryPolicy != "" {
+		retryPolicy = j.RetryPolicy
+	}
+	return []interface{}{
+		j.Name,
+		j.Queue,
+		j.Codec,
+		jsonPayload(j),
+		rawPayload(j),
+		j.Status,
+		j.Priority,
+		j.RunAfter,
+		j.Timeout,
+		j.RunCount,
+		j.RetryDelay,
+		uniqueKey,
+		j.MaxAttempts,
+		retryPolicy,
+	}
+}
+
+// enqueueBatchSize keeps a multi-row INSERT below the limit of 65535 bind parameters.
+const enqueueBatchSize = 1000
+
+// insertColumns is the number of bind parameters of a job in a multi-row INSERT.
+const insertColumns = 14
+
+// EnqueueMany validates and inserts jobs in batches within a transaction,
+// and returns the assigned IDs in the order of jobs. ID of each job is also set.
+// None of the jobs are inserted if any of them is invalid, or is a duplicate of a pending
+// or running job, which is reported as *DuplicateJobError. Jobs with ReturnDuplicate or
+// ReplaceDuplicate are saved one by one like Save, and set to the existing or replaced job.
+func (c *Client) EnqueueMany(ctx context.Context, jobs []Job) ([]int64, error) {
+	for i := range jobs {
+		if err := c.prepareJob(&jobs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	saved := make([]Job, len(jobs))
+	copy(saved, jobs)
+	var batched []int
+	for i := range saved {
+		if saved[i].UniqueKey == "" || saved[i].onDuplicate == ErrorOnDuplicate {
+			batched = append(batched, i)
+			continue
+		}
+		if err = c.saveUnique(ctx, tx, &saved[i], insertArgs(&saved[i])); err != nil {
+			return nil, err
+		}
+	}
+	for start := 0; start < len(batched); start += enqueueBatchSize {
+		end := start + enqueueBatchSize
+		if end > len(batched) {
+			end = len(batched)
+		}
+		batch := make([]Job, 0, end-start)
+		for _, i := range batched[start:end] {
+			batch = append(batch, saved[i])
+		}
+		batchIDs, err := c.insertJobs(ctx, tx, batch)
+		if err != nil {
+			return nil, c.duplicateError(err)
+		}
+		for n, i := range batched[start:end] {
+			saved[i].ID = batchIDs[n]
+		}
+	}
+	notified := make(map[string]bool)
+	for _, j := range saved {
+		if notified[j.Queue] {
+			continue
+		}
+		if err = c.notify(ctx, tx, j.Queue); err != nil {
+			return nil, err
+		}
+		notified[j.Queue] = true
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(saved))
+	for i := range saved {
+		jobs[i] = saved[i]
+		ids[i] = saved[i].ID
+	}
+	return ids, nil
+}
+
+func (c *Client) insertJobs(ctx context.Context, tx *sql.Tx, jobs []Job) ([]int64, error) {
+	var query strings.Builder
+	query.WriteString(c.stmt(`INSERT INTO {job} (name,queue,codec,payload,raw_payload,status,priority,run_after,timeout,run_count,retry_delay,unique_key,max_attempts,retry_policy,last_error) VALUES `))
+	args := make([]interface{}, 0, len(jobs)*insertColumns)
+	for i, j := range jobs {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		query.WriteString("(")
+		for n := len(args) + 1; n <= len(args)+insertColumns; n++ {
+			fmt.Fprintf(&query, "$%d,", n)
+		}
+		query.WriteString("'')")
+		args = append(args, insertArgs(&j)...)
+	}
+	query.WriteString(" RETURNING id")
+
+	rows, err := tx.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, len(jobs))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The serial is drawn row by row in VALUES order, so ascending IDs follow the order of jobs.
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids, nil
+}
+
+// prepareJob fills default values of a job to insert, and validates it.
+func (c *Client) prepareJob(j *Job) error {
+	if j.Queue == "" {
+		j.Queue = DefaultQueue
+	}
+	if j.Codec == "" {
+		j.Codec = c.codecName(j.Name)
+	}
+	if j.uniqueByPayload && j.UniqueKey == "" {
+		j.UniqueKey = payloadKey(j)
+	}
+	if j.MaxAttempts == 0 {
+		j.MaxAttempts = jobConfig.MaxRetryCount
+	}
+	err := validate.Struct(j)
+	if err != nil {
+		return err
+	}
+	if j.Codec == (JSONCodec{}).Name() && len(j.Payload) > 0 {
+		var payload interface{}
+		err = json.Unmarshal(j.Payload, &payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonPayload returns the value of the jsonb payload column.
+func jsonPayload(j *Job) interface{} {
+	if j.Codec != (JSONCodec{}).Name() || len(j.Payload) == 0 {
+		return nil
+	}
+	return string(j.Payload)
+}
+
+// rawPayload returns the value of the raw_payload column, used by codecs other than JSON.
+func rawPayload(j *Job) interface{} {
+	if j.Codec == (JSONCodec{}).Name() {
+		return nil
+	}
+	return []byte(j.Payload)
+}
+
+// Delete removes a job.
+func (c *Client) Delete(ctx context.Context, j *Job) error {
+	var id int64
+	return c.db.QueryRowContext(ctx, c.stmt(`DELETE FROM {job} WHERE id = $1 RETURNING id`), j.ID).Scan(&id)
+}
+
+// LockJobs locks rows and returns jobs. The lock is a lease stored in the row,
+// so it holds across connections of a pool, and the jobs may be completed from any of them.
+// The lease expires in 30 seconds, after which dispatchers re-queue the jobs.
+// If queues are given, only jobs in them are locked.
+func (c *Client) LockJobs(ctx context.Context, length int, queues ...string) ([]Job, error) {
+	return c.lockJobs(ctx, length, queues, nil, c.lockID, defaultLeaseTTL)
+}
+
+// lockJobs locks jobs in queues whose names are in names for lockedBy with a lease of ttl.
+// nil matches every queue or name.
+// Rows being locked by other dispatchers are skipped instead of waited for.
+func (c *Client) lockJobs(ctx context.Context, length int, queues []string, names []string, lockedBy string, ttl time.Duration) ([]Job, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`UPDATE {job} SET locked_at = now(), locked_by = $4, heartbeat_at = now(), lease_expires_at = now() + make_interval(secs => $5) WHERE id IN (SELECT id FROM {job} WHERE locked_at IS NULL AND run_after <= now() AND status = 0 AND cancelled_at IS NULL AND ($2::text[] IS NULL OR queue = ANY($2)) AND ($3::text[] IS NULL OR name = ANY($3)) ORDER BY priority desc LIMIT $1 FOR UPDATE SKIP LOCKED) RETURNING id, name, queue, codec, {payload}, run_after, timeout, run_count, retry_delay, locked_by, coalesce(max_attempts, 0), coalesce(retry_policy, ''), snooze_count`), length, pq.StringArray(queues), pq.StringArray(names), lockedBy, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j := Job{}
+		err := rows.Scan(
+			&j.ID,
+			&j.Name,
+			&j.Queue,
+			&j.Codec,
+			&j.Payload,
+			&j.RunAfter,
+			&j.Timeout,
+			&j.RunCount,
+			&j.RetryDelay,
+			&j.LockedBy,
+			&j.MaxAttempts,
+			&j.RetryPolicy,
+			&j.SnoozeCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// UnlockJobs unlocks rows locked by LockJobs of the client. Jobs whose cancel was requested are cancelled.
+func (c *Client) UnlockJobs(ctx context.Context) error {
+	return c.unlockJobs(ctx, c.lockID)
+}
+
+// unlockJobs unlocks pending jobs locked by lockedBy.
+func (c *Client) unlockJobs(ctx context.Context, lockedBy string) error {
+	_, err := c.db.ExecContext(ctx, c.stmt(`UPDATE {job} SET locked_at = null, locked_by = null, status = CASE WHEN cancelled_at IS NULL THEN 0 ELSE 3 END WHERE status = 0 AND locked_by = $1`), lockedBy)
+	return err
+}
+
+// ReleaseJobs set locked_at = null, which status = 0, whoever locked them.
+// Jobs whose cancel was requested are cancelled.
+func (c *Client) ReleaseJobs(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, c.stmt(`UPDATE {job} SET locked_at = null, locked_by = null, status = CASE WHEN cancelled_at IS NULL THEN 0 ELSE 3 END WHERE status = 0 AND locked_at IS NOT NULL`))
+	return err
+}
+
+// Complete done a job. It returns ErrLeaseExpired if the job was locked by the caller, and
+// its lease expired since.
+func (c *Client) Complete(ctx context.Context, j *Job) error {
+	res, err := c.db.ExecContext(ctx, c.stmt(`WITH updated AS (UPDATE {job} SET status = 1, run_count = $2, elapsed = $3, locked_at = null, locked_by = null WHERE ID = $1`+heldBy("$4")+` RETURNING id, run_count) `+attemptSQL(AttemptCompleted, "$4", "$5", "$3", "''")), j.ID, j.RunCount+1, j.Elapsed, j.LockedBy, startedAt(j))
+	if err = leaseHeld(res, err); err != nil {
+		return err
+	}
+	j.Status = StatusProcessed
+	j.RunCount++
+	j.LockedBy = ""
+
+	c.logger.Log(ctx, slog.LevelInfo, "Processed job", c.jobAttrs(j)...)
+	return nil
+}
+
+// maxRetryDelay is the largest retry_delay in seconds, which is an integer column.
+const maxRetryDelay = math.MaxInt32
+
+// Fail re-queues a job by its retry policy, or makes failed status if run count reaches max attempts.
+// It returns ErrLeaseExpired like Complete.
+func (c *Client) Fail(ctx context.Context, j *Job, errStr string) error {
+	return c.fail(ctx, j, errors.New(errStr), nil)
+}
+
+// fail records runErr, and re-queues the job by its retry policy, falling back to the policy of
+// its handler. Permanent errors make failed status without retrying.
+func (c *Client) fail(ctx context.Context, j *Job, runErr error, handler RetryPolicy) error {
+	errStr := runErr.Error()
+	runCount := j.RunCount + 1
+
+	if IsPermanent(runErr) || runCount >= maxAttempts(j) {
+		res, err := c.db.ExecContext(ctx, c.stmt(`WITH updated AS (UPDATE {job} SET status = 2, run_count = $2, elapsed = $3, last_error = $4, locked_at = null, locked_by = null WHERE id = $1`+heldBy("$5")+` RETURNING id, run_count) `+attemptSQL(AttemptFailed, "$5", "$6", "$3", "$4")), j.ID, runCount, j.Elapsed, errStr, j.LockedBy, startedAt(j))
+		if err = leaseHeld(res, err); err != nil {
+			return err
+		}
+		j.Status = StatusFailed
+	} else {
+		delay := j.RetryDelay
+		var runAfter time.Time
+		if j.RetryPolicy != "" && c.retryPolicies[j.RetryPolicy] == nil {
+			c.logger.Log(ctx, slog.LevelWarn, "Unknown retry policy", append(c.jobAttrs(j), slog.String("retry_policy", j.RetryPolicy))...)
+		}
+		if p := c.policyOf(j, handler); p != nil {
+			backoff := p.Backoff(runCount, runErr)
+			runAfter = time.Now().Add(backoff)
+			delay = 0
+			if backoff > 0 {
+				delay = uint(min(backoff.Round(time.Second)/time.Second, maxRetryDelay))
+			}
+		} else {
+			delay = runCount*runCount*runCount*runCount + j.Timeout + j.RetryDelay + 15
+			if delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			runAfter = j.RunAfter.Add(time.Duration(delay) * time.Second)
+		}
+		// A job cancelled while running is not retried.
+		err := c.db.QueryRowContext(
+			ctx,
+			c.stmt(`WITH updated AS (UPDATE {job} SET run_count = $2, retry_delay = $3, run_after = $4, elapsed = $5, last_error = $6, locked_at = null, locked_by = null, status = CASE WHEN cancelled_at IS NULL THEN 0 ELSE 3 END WHERE id = $1`+heldBy("$7")+` RETURNING id, run_count, status), attempt AS (`+attemptSQL(AttemptFailed, "$7", "$8", "$5", "$6")+`) SELECT status FROM updated`),
+			j.ID,
+			runCount,
+			delay,
+			runAfter,
+			j.Elapsed,
+			errStr,
+			j.LockedBy,
+			startedAt(j),
+		).Scan(&j.Status)
+		if err == sql.ErrNoRows {
+			return ErrLeaseExpired
+		}
+		if err != nil {
+			return err
+		}
+		j.RetryDelay = delay
+		j.RunAfter = runAfter
+	}
+	j.RunCount++
+	j.LockedBy = ""
+	c.logger.Log(ctx, slog.LevelWarn, "Failed job", append(c.jobAttrs(j), slog.String("error", errStr))...)
+	return nil
+}
+
+// EnqueuedJobsByName returns jobs, specific job is not run yet.
+func (c *Client) EnqueuedJobsByName(ctx context.Context, name string) ([]Job, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`SELECT id, name, queue, codec, {payload}, status, priority, run_after, timeout, run_count FROM {job} WHERE run_after > now() and name = $1 ORDER BY run_after desc, id desc`), name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j := Job{}
+		err := rows.Scan(
+			&j.ID,
+			&j.Name,
+			&j.Queue,
+			&j.Codec,
+			&j.Payload,
+			&j.Status,
+			&j.Priority,
+			&j.RunAfter,
+			&j.Timeout,
+			&j.RunCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// ProcessingJobs returns jobs, which status is done
+func (c *Client) ProcessingJobs(ctx context.Context) ([]Job, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`SELECT id, name, queue, codec, {payload}, status, priority, run_after, timeout, run_count, coalesce(locked_by, '') FROM {job} WHERE status = 0 AND locked_at is not null`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j := Job{}
+		err := rows.Scan(
+			&j.ID,
+			&j.Name,
+			&j.Queue,
+			&j.Codec,
+			&j.Payload,
+			&j.Status,
+			&j.Priority,
+			&j.RunAfter,
+			&j.Timeout,
+			&j.RunCount,
+			&j.LockedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// ProcessedJobs returns jobs, which status is done
+func (c *Client) ProcessedJobs(ctx context.Context, prevTime time.Time, prevID int64) ([]Job, error) {
+	return c.finishedJobs(ctx, StatusProcessed, prevTime, prevID)
+}
+
+// FailedJobs returns jobs, which status is failed
+func (c *Client) FailedJobs(ctx context.Context, prevTime time.Time, prevID int64) ([]Job, error) {
+	return c.finishedJobs(ctx, StatusFailed, prevTime, prevID)
+}
+
+// CancelledJobs returns jobs, which status is cancelled
+func (c *Client) CancelledJobs(ctx context.Context, prevTime time.Time, prevID int64) ([]Job, error) {
+	return c.finishedJobs(ctx, StatusCancelled, prevTime, prevID)
+}
+
+func (c *Client) finishedJobs(ctx context.Context, status uint, prevTime time.Time, prevID int64) ([]Job, error) {
+	var rows *sql.Rows
+	var err error
+	if prevTime.IsZero() {
+		query := c.stmt(`SELECT id, name, queue, codec, {payload}, status, priority, run_after, timeout, run_count, coalesce(elapsed, 0), coalesce(last_error, '') FROM {job} WHERE status = $1 ORDER BY run_after desc, id desc limit 25`)
+		rows, err = c.db.QueryContext(ctx, query, status)
+	} else {
+		query := c.stmt(`SELECT id, name, queue, codec, {payload}, status, priority, run_after, timeout, run_count, coalesce(elapsed, 0), coalesce(last_error, '') FROM {job} WHERE status = $1 AND (run_after, id) < ($2, $3) ORDER BY run_after desc, id desc limit 25`)
+		rows, err = c.db.QueryContext(ctx, query, status, prevTime, prevID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j := Job{}
+		err := rows.Scan(
+			&j.ID,
+			&j.Name,
+			&j.Queue,
+			&j.Codec,
+			&j.Payload,
+			&j.Status,
+			&j.Priority,
+			&j.RunAfter,
+			&j.Timeout,
+			&j.RunCount,
+			&j.Elapsed,
+			&j.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
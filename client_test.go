@@ -0,0 +1,143 @@
+package pqueue
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	c, err := Open(dsn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DB().Close()
+
+	if err = c.DB().Ping(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewClientSharesDB(t *testing.T) {
+	TruncateJob()
+
+	c := NewClient(DefaultClient().DB())
+	if c.DB() != DefaultClient().DB() {
+		t.Error("NewClient should use the given database handle")
+	}
+
+	job := NewJob("test", nil, 5)
+	if err := c.Save(context.Background(), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 1 {
+		t.Errorf("locked jobs expect 1, actual %d", len(jobs))
+		return
+	}
+	if jobs[0].ID != job.ID {
+		t.Error("invalid locked job")
+	}
+}
+
+func TestClientDispatcher(t *testing.T) {
+	c := NewClient(DefaultClient().DB())
+	d := c.NewDispatcher(1, worker{})
+	if d.client != c {
+		t.Error("dispatcher should use the client")
+	}
+}
+
+func TestWithSchemaAndTable(t *testing.T) {
+	TruncateJob()
+
+	ctx := context.Background()
+	c := NewClient(DefaultClient().DB(), WithSchema("pqueue test"), WithTable(`queue"jobs`))
+	if err := c.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.DB().Exec(`DROP SCHEMA "pqueue test" CASCADE`)
+
+	version, err := c.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == 0 {
+		t.Error("schema version should be recorded for the table")
+	}
+
+	job := NewJob("test", nil, 5)
+	if err = c.Save(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 0 {
+		t.Errorf("jobs of the default table expect 0, actual %d", len(jobs))
+	}
+	jobs, _ = c.LockJobs(ctx, 1)
+	if len(jobs) != 1 {
+		t.Errorf("jobs of the configured table expect 1, actual %d", len(jobs))
+	}
+}
+
+func TestLockJobsLease(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		j := NewJob("test", nil, 5)
+		j.Save()
+	}
+
+	// Locks of concurrent clients never overlap.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[int64]bool)
+	clients := []*Client{DefaultClient(), NewClient(DefaultClient().DB())}
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				jobs, err := c.LockJobs(ctx, 2)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				for _, j := range jobs {
+					if seen[j.ID] {
+						t.Errorf("job %d is locked twice", j.ID)
+					}
+					if j.LockedBy != c.lockID {
+						t.Errorf("expect locked by %s, actual %s", c.lockID, j.LockedBy)
+					}
+					seen[j.ID] = true
+				}
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if len(seen) != 20 {
+		t.Errorf("locked jobs expect 20, actual %d", len(seen))
+	}
+
+	// UnlockJobs releases only the jobs of the client.
+	if err := clients[1].UnlockJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := ProcessingJobs()
+	for _, j := range jobs {
+		if j.LockedBy != DefaultClient().lockID {
+			t.Errorf("jobs of the other client should be unlocked, locked by %s", j.LockedBy)
+		}
+	}
+	UnlockJobs()
+	jobs, _ = ProcessingJobs()
+	if len(jobs) != 0 {
+		t.Errorf("processing jobs expect 0, actual %d", len(jobs))
+	}
+}
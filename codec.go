@@ -0,0 +1,93 @@
+package pqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes and decodes payloads. The name of the codec is stored with each job,
+// so workers decode payloads correctly even after the codec of a job name changes.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is the default codec. Payloads of the JSON codec are validated on Save.
+type JSONCodec struct{}
+
+// Name returns json.
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+// Marshal encodes v into JSON.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON into v.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// WithCodecs registers codecs to decode payloads.
+func WithCodecs(codecs ...Codec) ClientOption {
+	return func(c *Client) {
+		for _, codec := range codecs {
+			c.codecs[codec.Name()] = codec
+		}
+	}
+}
+
+// WithDefaultCodec registers a codec, and encodes payloads with it.
+func WithDefaultCodec(codec Codec) ClientOption {
+	return func(c *Client) {
+		c.codecs[codec.Name()] = codec
+		c.defaultCodec = codec.Name()
+	}
+}
+
+// WithJobCodec registers a codec, and encodes payloads of the job name with it.
+func WithJobCodec(name string, codec Codec) ClientOption {
+	return func(c *Client) {
+		c.codecs[codec.Name()] = codec
+		c.jobCodecs[name] = codec.Name()
+	}
+}
+
+// codecName returns the name of the codec encoding payloads of the job name.
+func (c *Client) codecName(name string) string {
+	if codec, ok := c.jobCodecs[name]; ok {
+		return codec
+	}
+	return c.defaultCodec
+}
+
+// codec returns the registered codec of the name.
+func (c *Client) codec(name string) (Codec, error) {
+	codec, ok := c.codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("pqueue: unknown codec %s", name)
+	}
+	return codec, nil
+}
+
+type clientKey struct{}
+
+// withClient makes the client of a dispatcher available to its worker.
+func withClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// decoder returns the codec of a job run by a dispatcher. Outside a dispatcher only JSON is known.
+func decoder(ctx context.Context, name string) (Codec, error) {
+	if c, ok := ctx.Value(clientKey{}).(*Client); ok {
+		return c.codec(name)
+	}
+	if name == "" || name == (JSONCodec{}).Name() {
+		return JSONCodec{}, nil
+	}
+	return nil, fmt.Errorf("pqueue: unknown codec %s", name)
+}
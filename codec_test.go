@@ -0,0 +1,88 @@
+package pqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"testing"
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func TestCodecName(t *testing.T) {
+	c := NewClient(nil, WithDefaultCodec(gobCodec{}), WithJobCodec("mail", JSONCodec{}))
+	if name := c.codecName("report"); name != "gob" {
+		t.Errorf("expect default codec gob, actual %s", name)
+	}
+	if name := c.codecName("mail"); name != "json" {
+		t.Errorf("expect job codec json, actual %s", name)
+	}
+	if _, err := c.codec("msgpack"); err == nil {
+		t.Error("unknown codec should be an error")
+	}
+}
+
+func TestHandleDecodesWithCodec(t *testing.T) {
+	c := NewClient(nil, WithCodecs(gobCodec{}))
+	b, _ := gobCodec{}.Marshal(mailPayload{To: "someone@example.com"})
+
+	m := NewMux()
+	var got mailPayload
+	Handle(m, "mail", func(ctx context.Context, p mailPayload, meta JobMeta) error {
+		got = p
+		return nil
+	})
+	err := m.Run(withClient(context.Background(), c), Job{Name: "mail", Codec: "gob", Payload: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.To != "someone@example.com" {
+		t.Errorf("expect decoded payload, actual %+v", got)
+	}
+
+	err = m.Run(context.Background(), Job{Name: "mail", Codec: "gob", Payload: b})
+	if !IsPermanent(err) {
+		t.Errorf("unknown codec should be a permanent error, actual %v", err)
+	}
+}
+
+func TestSaveWithCodec(t *testing.T) {
+	TruncateJob()
+
+	ctx := context.Background()
+	c := NewClient(DefaultClient().DB(), WithJobCodec("mail", gobCodec{}))
+	job, err := EnqueueWith(ctx, c, "mail", mailPayload{To: "someone@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Codec != "gob" {
+		t.Errorf("expect codec gob, actual %s", job.Codec)
+	}
+
+	job = NewJob("report", []byte("not json"), 5, WithCodec("gob"))
+	if err = c.Save(ctx, &job); err != nil {
+		t.Error("payloads of other codecs should not be validated as JSON")
+	}
+
+	jobs, _ := c.LockJobs(ctx, 2)
+	if len(jobs) != 2 {
+		t.Fatalf("locked jobs expect 2, actual %d", len(jobs))
+	}
+	for _, j := range jobs {
+		if j.Codec != "gob" {
+			t.Errorf("expect codec gob, actual %s", j.Codec)
+		}
+	}
+}
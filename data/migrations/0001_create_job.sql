@@ -1,4 +1,4 @@
-CREATE TABLE IF NOT EXISTS "job" (
+CREATE TABLE IF NOT EXISTS {job} (
   id BIGSERIAL PRIMARY KEY,
   name VARCHAR(255) NOT NULL,
   payload bytea,
@@ -13,4 +13,4 @@ CREATE TABLE IF NOT EXISTS "job" (
   last_error text
 );
 
-CREATE INDEX IF NOT EXISTS "job_next_at_key" ON "job" (run_after);
+CREATE INDEX IF NOT EXISTS "{job_name}_next_at_key" ON {job} (run_after);
@@ -0,0 +1,3 @@
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS queue VARCHAR(255) NOT NULL DEFAULT 'default';
+
+CREATE INDEX IF NOT EXISTS "{job_name}_queue_key" ON {job} (queue, run_after);
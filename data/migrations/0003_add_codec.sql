@@ -0,0 +1 @@
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS codec VARCHAR(32) NOT NULL DEFAULT 'json';
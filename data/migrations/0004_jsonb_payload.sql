@@ -0,0 +1,6 @@
+-- Payloads of JSON codec are stored as jsonb to be searchable, others as they are.
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS raw_payload bytea;
+
+UPDATE {job} SET raw_payload = payload, payload = NULL WHERE codec <> 'json';
+
+ALTER TABLE {job} ALTER COLUMN payload TYPE jsonb USING NULLIF(convert_from(payload, 'UTF8'), '')::jsonb;
@@ -0,0 +1,4 @@
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS unique_key VARCHAR(255);
+
+-- Only one pending or running job may have a unique key.
+CREATE UNIQUE INDEX IF NOT EXISTS "{job_name}_unique_key" ON {job} (unique_key) WHERE unique_key IS NOT NULL AND status = 0;
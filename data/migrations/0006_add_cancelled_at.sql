@@ -0,0 +1,2 @@
+-- A running job is cancelled by its dispatcher once cancelled_at is set.
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS cancelled_at timestamp with time zone;
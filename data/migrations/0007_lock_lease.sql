@@ -0,0 +1,5 @@
+-- Jobs are locked by row locks and a lease instead of advisory locks.
+ALTER TABLE {job} RENAME COLUMN grabbed TO locked_at;
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS locked_by VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS "{job_name}_lockable_key" ON {job} (priority DESC) WHERE status = 0 AND locked_at IS NULL;
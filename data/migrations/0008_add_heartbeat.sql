@@ -0,0 +1,7 @@
+-- Dispatchers renew the lease of their jobs by heartbeat_at and lease_expires_at. Each lock stores
+-- when its lease expires, so dispatchers with different leases reap only expired jobs.
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS heartbeat_at timestamp with time zone;
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS lease_expires_at timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS "{job_name}_locked_key" ON {job} (heartbeat_at) WHERE status = 0 AND locked_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS "{job_name}_lease_key" ON {job} (lease_expires_at) WHERE status = 0 AND locked_at IS NOT NULL;
@@ -0,0 +1,16 @@
+-- Dispatchers register themselves, so locked_by of jobs refers to a worker.
+-- The table is shared by every job table in the schema.
+CREATE TABLE IF NOT EXISTS {workers} (
+  id VARCHAR(255) PRIMARY KEY,
+  job_table text NOT NULL,
+  hostname text NOT NULL,
+  pid integer NOT NULL,
+  queues text[],
+  concurrency integer NOT NULL,
+  version text NOT NULL DEFAULT '',
+  lease_ttl real NOT NULL DEFAULT 30,
+  started_at timestamp with time zone NOT NULL DEFAULT now(),
+  last_seen timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS "{job_name}_locked_by_key" ON {job} (locked_by) WHERE status = 0 AND locked_by IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Retries are limited per job. max_attempts of older jobs is NULL, which falls back to JobConfig.MaxRetryCount.
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS max_attempts smallint;
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS retry_policy VARCHAR(64);
+-- Backoffs of policies and the default backoff of later attempts exceed smallint seconds.
+ALTER TABLE {job} ALTER COLUMN retry_delay TYPE integer, ALTER COLUMN timeout TYPE integer;
@@ -0,0 +1,2 @@
+-- Snoozed runs re-queue a job without counting an attempt, and are counted here instead.
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS snooze_count integer NOT NULL DEFAULT 0;
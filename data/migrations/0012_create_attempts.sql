@@ -0,0 +1,14 @@
+-- Each run of a job is recorded, since the job row keeps only the last one.
+CREATE TABLE IF NOT EXISTS {attempts} (
+  id BIGSERIAL PRIMARY KEY,
+  job_id bigint NOT NULL REFERENCES {job} (id) ON DELETE CASCADE,
+  attempt integer NOT NULL,
+  worker_id VARCHAR(255),
+  started_at timestamp with time zone NOT NULL,
+  finished_at timestamp with time zone NOT NULL,
+  duration real NOT NULL,
+  outcome VARCHAR(32) NOT NULL,
+  error text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS "{job_name}_attempts_job_key" ON {attempts} (job_id, attempt);
@@ -0,0 +1,5 @@
+-- Who retried or discarded failed jobs, for auditing.
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS retried_at timestamp with time zone;
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS retried_by VARCHAR(255);
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS discarded_at timestamp with time zone;
+ALTER TABLE {job} ADD COLUMN IF NOT EXISTS discarded_by VARCHAR(255);
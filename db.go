@@ -1,8 +1,6 @@
 package pqueue
 
 import (
-	"database/sql"
-
 	// pq package called only init
 	_ "github.com/lib/pq"
 )
@@ -11,11 +9,25 @@ func dsn() string {
 	return cached.m["psql_dsn"]
 }
 
-var db *sql.DB
+var defaultClient *Client
 
-// NewDB creates an instance of DB handler
+// NewDB creates the default client from the psql_dsn configuration.
+// Package level functions and Job methods use the default client.
 func NewDB() error {
-	var err error
-	db, err = sql.Open("postgres", dsn())
-	return err
+	c, err := Open(dsn())
+	if err != nil {
+		return err
+	}
+	defaultClient = c
+	return nil
+}
+
+// DefaultClient returns the client used by package level functions.
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// SetDefaultClient replaces the client used by package level functions.
+func SetDefaultClient(c *Client) {
+	defaultClient = c
 }
@@ -1,6 +1,7 @@
 package pqueue
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -14,9 +15,13 @@ func runMain(m *testing.M) int {
 	if err != nil {
 		return 1
 	}
+	err = Migrate(context.Background(), DefaultClient().DB())
+	if err != nil {
+		return 1
+	}
 	return m.Run()
 }
 
 func TruncateJob() {
-	db.Exec("TRUNCATE job")
+	defaultClient.DB().Exec("TRUNCATE job CASCADE")
 }
@@ -0,0 +1,194 @@
+package pqueue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrJobNotFailed is returned by RetryJob for a job which is not failed or does not exist.
+var ErrJobNotFailed = errors.New("pqueue: job is not failed")
+
+// ManageOption configures RetryJob, RetryFailedJobs and DiscardJobs.
+type ManageOption func(*manageOptions)
+
+type manageOptions struct {
+	actor   string
+	payload []byte
+}
+
+// By records who retries or discards jobs. The default is the hostname and pid of the process.
+func By(actor string) ManageOption {
+	return func(o *manageOptions) {
+		o.actor = actor
+	}
+}
+
+// WithNewPayload replaces the payload of a job retried by RetryJob, e.g. to fix a malformed one.
+// It is encoded by the codec of the job. RetryFailedJobs rejects it, since it would overwrite
+// the payloads of every matched job.
+func WithNewPayload(payload json.RawMessage) ManageOption {
+	return func(o *manageOptions) {
+		o.payload = payload
+	}
+}
+
+func newManageOptions(opts []ManageOption) manageOptions {
+	host, _ := os.Hostname()
+	o := manageOptions{actor: fmt.Sprintf("%s:%d", host, os.Getpid())}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// retrySQL re-queues failed jobs of conds. $1 is the actor, and $2 the new payload or NULL.
+// run_count restarts, so attempts of a retried job are numbered from 1 again.
+const retrySQL = `UPDATE {job} SET status = 0, run_count = 0, run_after = now(), last_error = '', locked_at = null, locked_by = null, heartbeat_at = null, lease_expires_at = null, cancelled_at = null, retried_at = now(), retried_by = $1, ` +
+	`payload = CASE WHEN $2::bytea IS NULL OR codec <> 'json' THEN payload ELSE convert_from($2, 'UTF8')::jsonb END, ` +
+	`raw_payload = CASE WHEN $2::bytea IS NULL OR codec = 'json' THEN raw_payload ELSE $2 END WHERE status = 2`
+
+// RetryJob re-queues a failed job to run now with run count 0.
+func (c *Client) RetryJob(ctx context.Context, id int64, opts ...ManageOption) error {
+	o := newManageOptions(opts)
+	if o.payload != nil {
+		var codec string
+		err := c.db.QueryRowContext(ctx, c.stmt(`SELECT codec FROM {job} WHERE id = $1`), id).Scan(&codec)
+		if err == sql.ErrNoRows {
+			return ErrJobNotFailed
+		}
+		if err != nil {
+			return err
+		}
+		if codec == (JSONCodec{}).Name() && !json.Valid(o.payload) {
+			return fmt.Errorf("pqueue: invalid payload %s", o.payload)
+		}
+	}
+	var queue string
+	err := c.db.QueryRowContext(ctx, c.stmt(retrySQL+` AND id = $3 RETURNING queue`), o.actor, nullBytes(o.payload), id).Scan(&queue)
+	if err == sql.ErrNoRows {
+		return ErrJobNotFailed
+	}
+	if err != nil {
+		return c.duplicateError(err)
+	}
+
+	c.logger.Log(ctx, slog.LevelInfo, "Retried job", slog.Int64("job_id", id), slog.String("by", o.actor))
+	return c.notify(ctx, c.db, queue)
+}
+
+// RetryFailedJobs re-queues failed jobs matching the filter, and returns the number of them.
+// Statuses, Limit and pagination of the filter are ignored. Use RetryJob to replace a payload.
+func (c *Client) RetryFailedJobs(ctx context.Context, f JobFilter, opts ...ManageOption) (int64, error) {
+	if len(f.Payload) > 0 && !json.Valid(f.Payload) {
+		return 0, fmt.Errorf("pqueue: invalid payload filter %s", f.Payload)
+	}
+	o := newManageOptions(opts)
+	if o.payload != nil {
+		return 0, errors.New("pqueue: WithNewPayload is only for RetryJob")
+	}
+	conds, args := bulkFilter(f).where([]interface{}{o.actor, nullBytes(o.payload)})
+	query := c.stmt(retrySQL) + andConds(conds) + ` RETURNING queue`
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, c.duplicateError(err)
+	}
+	defer rows.Close()
+
+	var n int64
+	queues := make(map[string]bool)
+	for rows.Next() {
+		var queue string
+		if err := rows.Scan(&queue); err != nil {
+			return n, err
+		}
+		queues[queue] = true
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, c.duplicateError(err)
+	}
+
+	c.logger.Log(ctx, slog.LevelInfo, "Retried jobs", slog.Int64("count", n), slog.String("by", o.actor))
+	for queue := range queues {
+		if err := c.notify(ctx, c.db, queue); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// DiscardJobs makes discarded status of jobs matching the filter, and returns the number of them.
+// Discarded jobs are kept with who discarded them. Only failed jobs are discarded unless Statuses
+// of the filter are given, and running jobs are never discarded. Limit and pagination are ignored.
+func (c *Client) DiscardJobs(ctx context.Context, f JobFilter, opts ...ManageOption) (int64, error) {
+	if len(f.Payload) > 0 && !json.Valid(f.Payload) {
+		return 0, fmt.Errorf("pqueue: invalid payload filter %s", f.Payload)
+	}
+	o := newManageOptions(opts)
+	statuses := f.Statuses
+	if len(statuses) == 0 {
+		statuses = []uint{StatusFailed}
+	}
+	f = bulkFilter(f)
+	f.Statuses = statuses
+	conds, args := f.where([]interface{}{o.actor})
+	query := c.stmt(`UPDATE {job} SET status = 4, discarded_at = now(), discarded_by = $1 WHERE status <> 4 AND NOT (status = 0 AND locked_at IS NOT NULL)`) + andConds(conds)
+
+	res, err := c.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	c.logger.Log(ctx, slog.LevelInfo, "Discarded jobs", slog.Int64("count", n), slog.String("by", o.actor))
+	return n, nil
+}
+
+// bulkFilter clears the fields of a filter which only FindJobs uses.
+func bulkFilter(f JobFilter) JobFilter {
+	f.Statuses = nil
+	f.PrevTime = time.Time{}
+	f.PrevID = 0
+	f.Limit = 0
+	return f
+}
+
+// andConds turns the conditions of JobFilter.where into ones following other conditions.
+func andConds(conds string) string {
+	if conds == "" {
+		return ""
+	}
+	return " AND " + strings.TrimPrefix(conds, " WHERE ")
+}
+
+func nullBytes(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return b
+}
+
+// RetryJob re-queues a failed job using the default client.
+func RetryJob(ctx context.Context, id int64, opts ...ManageOption) error {
+	return defaultClient.RetryJob(ctx, id, opts...)
+}
+
+// RetryFailedJobs re-queues failed jobs matching the filter using the default client.
+func RetryFailedJobs(ctx context.Context, f JobFilter, opts ...ManageOption) (int64, error) {
+	return defaultClient.RetryFailedJobs(ctx, f, opts...)
+}
+
+// DiscardJobs discards jobs matching the filter using the default client.
+func DiscardJobs(ctx context.Context, f JobFilter, opts ...ManageOption) (int64, error) {
+	return defaultClient.DiscardJobs(ctx, f, opts...)
+}
@@ -0,0 +1,116 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failedJob saves a job of the name and fails it permanently.
+func failedJob(t *testing.T, name string) Job {
+	t.Helper()
+	ctx := context.Background()
+	j := NewJob(name, []byte(`{"n": 1}`), 5)
+	if err := j.Save(); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 1 {
+		t.Fatalf("expect 1 locked job, actual %d", len(jobs))
+	}
+	job := jobs[0]
+	if err := DefaultClient().fail(ctx, &job, Permanent(errors.New("boom")), nil); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestRetryJob(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	j := failedJob(t, "test")
+	if err := RetryJob(ctx, j.ID, WithNewPayload([]byte(`{"n":`))); err == nil {
+		t.Error("RetryJob should validate the new payload")
+	}
+	if err := RetryJob(ctx, j.ID, By("alice"), WithNewPayload([]byte(`{"n": 2}`))); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := FindJobs(ctx, JobFilter{Statuses: []uint{StatusPending}})
+	if len(jobs) != 1 {
+		t.Fatalf("expect 1 pending job, actual %d", len(jobs))
+	}
+	job := jobs[0]
+	if job.RunCount != 0 || job.LastError != "" {
+		t.Errorf("run count and last error should be reset, %v", job)
+	}
+	if job.RetriedBy != "alice" || job.RetriedAt.IsZero() {
+		t.Errorf("expect retried by alice, actual %q at %s", job.RetriedBy, job.RetriedAt)
+	}
+	if string(job.Payload) != `{"n": 2}` {
+		t.Errorf("expect new payload, actual %s", job.Payload)
+	}
+
+	locked, _ := LockJobs(1)
+	if len(locked) != 1 {
+		t.Error("retried job should be locked")
+	}
+
+	if err := RetryJob(ctx, j.ID); !errors.Is(err, ErrJobNotFailed) {
+		t.Errorf("expect ErrJobNotFailed, actual %v", err)
+	}
+}
+
+func TestRetryFailedJobs(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	failedJob(t, "test")
+	failedJob(t, "other")
+	if _, err := RetryFailedJobs(ctx, JobFilter{}, WithNewPayload([]byte(`{}`))); err == nil {
+		t.Error("RetryFailedJobs should reject a new payload")
+	}
+	n, err := RetryFailedJobs(ctx, JobFilter{Names: []string{"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expect 1 retried job, actual %d", n)
+	}
+
+	jobs, _ := FailedJobs(time.Time{}, 0)
+	if len(jobs) != 1 || jobs[0].Name != "other" {
+		t.Errorf("other jobs should stay failed, %v", jobs)
+	}
+}
+
+func TestDiscardJobs(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	failedJob(t, "test")
+	running := NewJob("test", nil, 5)
+	running.Save()
+	LockJobs(1)
+
+	n, err := DiscardJobs(ctx, JobFilter{Names: []string{"test"}, Statuses: []uint{StatusPending, StatusFailed}}, By("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("running jobs should not be discarded, discarded %d", n)
+	}
+
+	jobs, _ := FindJobs(ctx, JobFilter{Statuses: []uint{StatusDiscarded}})
+	if len(jobs) != 1 {
+		t.Fatalf("expect 1 discarded job, actual %d", len(jobs))
+	}
+	if jobs[0].DiscardedBy != "bob" || jobs[0].DiscardedAt.IsZero() {
+		t.Errorf("expect discarded by bob, actual %q at %s", jobs[0].DiscardedBy, jobs[0].DiscardedAt)
+	}
+	if err := RetryJob(ctx, jobs[0].ID); !errors.Is(err, ErrJobNotFailed) {
+		t.Errorf("discarded jobs should not be retried, %v", err)
+	}
+}
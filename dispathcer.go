@@ -2,9 +2,23 @@ package pqueue
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+// unlockTimeout bounds the query releasing jobs after a dispatcher is stopped forcibly.
+const unlockTimeout = 5 * time.Second
+
+const (
+	defaultStatusRetries = 5
+	defaultStatusBackoff = 100 * time.Millisecond
 )
 
 // Worker is an interface of a worker
@@ -12,44 +26,175 @@ type Worker interface {
 	Run(ctx context.Context, job Job) error
 }
 
-// NewDispatcher creates and returns dispatcher
-func NewDispatcher(max int, worker Worker) Dispatcher {
+// namer is implemented by workers which process only some job names, such as Mux.
+type namer interface {
+	Names() []string
+}
+
+// ErrorHandler is called when a dispatcher gives up recording the result of a job.
+type ErrorHandler func(job Job, err error)
+
+// DispatcherOption configures a dispatcher.
+type DispatcherOption func(*Dispatcher)
+
+// WithErrorHandler sets the handler of persistent failures. The default handler logs them
+// with the logger of the client.
+func WithErrorHandler(h ErrorHandler) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.errorHandler = h
+	}
+}
+
+// WithStatusRetry sets how many times a dispatcher tries to record the result of a job,
+// and the first delay between attempts. The delay doubles on each attempt.
+func WithStatusRetry(attempts int, backoff time.Duration) DispatcherOption {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return func(d *Dispatcher) {
+		d.statusRetries = attempts
+		d.statusBackoff = backoff
+	}
+}
+
+// WithQueues makes a dispatcher process only jobs in the queues. By default it processes every queue.
+func WithQueues(queues ...string) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.queues = queues
+	}
+}
+
+// WithListen makes a dispatcher wake as soon as jobs of its queues are saved, and lock jobs
+// only every poll otherwise, for delayed and retried jobs. Without it, a dispatcher locks jobs
+// on every interval of Start. The client needs a data source name, see WithDSN.
+func WithListen(poll time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.listenPoll = poll
+	}
+}
+
+// NewDispatcher creates and returns dispatcher using the default client.
+func NewDispatcher(max int, worker Worker, opts ...DispatcherOption) Dispatcher {
+	return defaultClient.NewDispatcher(max, worker, opts...)
+}
+
+// NewDispatcher creates and returns dispatcher which processes jobs of the client.
+func (c *Client) NewDispatcher(max int, worker Worker, opts ...DispatcherOption) Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
 	d := Dispatcher{
-		jobBuffer: make(chan Job, max),
-		sem:       make(chan struct{}, max),
-		worker:    worker,
-		stopTick:  make(chan struct{}),
-		stopLoop:  make(chan struct{}),
-		stopped:   make(chan struct{}),
+		client:        c,
+		ctx:           ctx,
+		cancel:        cancel,
+		jobBuffer:     make(chan Job, max),
+		sem:           make(chan struct{}, max),
+		worker:        worker,
+		statusRetries: defaultStatusRetries,
+		statusBackoff: defaultStatusBackoff,
+		stopTick:      make(chan struct{}),
+		stopLoop:      make(chan struct{}),
+		stopped:       make(chan struct{}, 1),
+		running:       newRunningJobs(),
+		wake:          make(chan struct{}, 1),
+		lockID:        newLockID(),
+		leaseTTL:      defaultLeaseTTL,
+		version:       buildVersion(),
+		panics:        new(atomic.Uint64),
+	}
+	for _, opt := range opts {
+		opt(&d)
 	}
 
 	return d
 }
 
+func (d *Dispatcher) handleError(job Job, err error) {
+	if d.errorHandler != nil {
+		d.errorHandler(job, err)
+		return
+	}
+	d.client.logger.Log(d.ctx, slog.LevelError, "Failed to record job", append(d.client.jobAttrs(&job), slog.Any("error", err))...)
+}
+
 // Dispatcher is used for queue
 type Dispatcher struct {
-	jobBuffer chan Job
-	sem       chan struct{}
-	worker    Worker
-	stopTick  chan struct{}
-	stopLoop  chan struct{}
-	stopped   chan struct{}
+	client *Client
+	// ctx lives until the dispatcher stops. Every query and worker run derives from it,
+	// so cancel aborts them.
+	ctx           context.Context
+	cancel        context.CancelFunc
+	jobBuffer     chan Job
+	sem           chan struct{}
+	worker        Worker
+	queues        []string
+	errorHandler  ErrorHandler
+	statusRetries int
+	statusBackoff time.Duration
+	stopTick      chan struct{}
+	stopLoop      chan struct{}
+	stopped       chan struct{}
+	running       *runningJobs
+	listenPoll    time.Duration
+	// wake is signaled when a job finishes, so a listening dispatcher with a backlog locks more jobs.
+	wake chan struct{}
+	// lockID identifies the dispatcher in the workers table, and in locked_by of its jobs.
+	lockID   string
+	leaseTTL time.Duration
+	version  string
+	panics   *atomic.Uint64
 }
 
 // Start starts a dispatcher
 func (d *Dispatcher) Start(interval time.Duration) {
+	if err := d.register(); err != nil {
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to register worker", slog.Any("error", err))
+	}
+	go d.keepLeases()
+
 	go func() {
 		ticker := time.NewTicker(interval * time.Millisecond)
-		max := cap(d.sem)
+		defer ticker.Stop()
+
+		// poll and notify stay nil unless the dispatcher listens.
+		var poll <-chan time.Time
+		var notify <-chan *pq.Notification
+		var backlog bool
+		if d.listenPoll > 0 {
+			l, err := d.client.listen()
+			if err != nil {
+				d.client.logger.Log(d.ctx, slog.LevelError, "Failed to listen, polling instead", slog.Any("error", err))
+			} else {
+				defer l.Close()
+				notify = l.Notify
+				pollTicker := time.NewTicker(d.listenPoll)
+				defer pollTicker.Stop()
+				poll = pollTicker.C
+				backlog = d.fill()
+			}
+		}
+
 		for {
 			select {
 			case <-ticker.C:
-				if len(d.sem) < max {
-					d.pop(max - len(d.sem))
+				d.cancelJobs()
+				if notify == nil {
+					d.fill()
+				}
+			case <-poll:
+				backlog = d.fill()
+			case n := <-notify:
+				// n is nil after reconnecting, when notifications may have been missed.
+				if n == nil || d.inQueues(n.Extra) {
+					backlog = d.fill()
+				}
+			case <-d.wake:
+				if backlog {
+					backlog = d.fill()
 				}
 			case <-d.stopTick:
-				ticker.Stop()
 				d.stopLoop <- struct{}{}
+				return
+			case <-d.ctx.Done():
+				return
 			}
 		}
 	}()
@@ -69,20 +214,39 @@ func (d *Dispatcher) Start(interval time.Duration) {
 					defer wg.Done()
 
 					start := time.Now()
-					ctx, cancel := context.WithTimeout(context.Background(), time.Duration(job.Timeout)*time.Second)
+					job.startedAt = start
+					jctx, cancelJob := context.WithCancelCause(withClient(d.ctx, d.client))
+					defer cancelJob(nil)
+					d.running.add(job.ID, cancelJob)
+					defer d.running.remove(job.ID)
+					if d.cancelledBeforeRun(job.ID) {
+						d.record(&job, ErrJobCancelled)
+						return
+					}
+					ctx, cancel := context.WithTimeout(jctx, time.Duration(job.Timeout)*time.Second)
 					defer cancel()
 
-					err := d.worker.Run(ctx, job)
+					err := d.run(ctx, job)
 					job.Elapsed = time.Now().Sub(start).Seconds()
-					if err != nil {
-						job.Fail(err.Error())
-					} else {
-						job.Complete()
+					switch cause := context.Cause(ctx); {
+					case errors.Is(cause, ErrLeaseExpired):
+						d.client.logger.Log(d.ctx, slog.LevelWarn, "Lost lease of job", d.client.jobAttrs(&job)...)
+					case err != nil && errors.Is(cause, ErrJobCancelled):
+						d.record(&job, ErrJobCancelled)
+					default:
+						d.record(&job, err)
+					}
+
+					select {
+					case d.wake <- struct{}{}:
+					default:
 					}
 				}(job)
 			case <-d.stopLoop:
 				wg.Wait()
 				break Loop
+			case <-d.ctx.Done():
+				break Loop
 			}
 		}
 
@@ -91,33 +255,155 @@ func (d *Dispatcher) Start(interval time.Duration) {
 }
 
 // Stop stops a dispatcher.
-// The dispatcher waits done every jobs.
+// The dispatcher waits done every jobs. If ctx is done before that,
+// running jobs and their queries are canceled. Jobs locked by the dispatcher which did not
+// finish or start are unlocked.
 func (d *Dispatcher) Stop(ctx context.Context) error {
-	d.stopTick <- struct{}{}
-
-	for {
+	// The ticker may be waiting for a query, which is canceled when ctx is done.
+	select {
+	case d.stopTick <- struct{}{}:
 		select {
-		case <-ctx.Done():
-			return UnlockJobs()
 		case <-d.stopped:
-			return nil
+		case <-ctx.Done():
 		}
+	case <-ctx.Done():
 	}
+	d.cancel()
+	defer d.deregister()
+	uctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	return d.client.unlockJobs(uctx, d.lockID)
 }
 
-// Stats logs running worker count
+// Stats logs running worker count, and the number of recovered panics
 func (d *Dispatcher) Stats() {
-	log.Printf("run count: %d", len(d.sem))
+	d.client.logger.Log(d.ctx, slog.LevelInfo, "Dispatcher stats", slog.Int("run_count", len(d.sem)), slog.Uint64("panics", d.Panics()))
+}
+
+// Panics returns the number of panics recovered from the worker.
+func (d *Dispatcher) Panics() uint64 {
+	return d.panics.Load()
+}
+
+// run runs the worker. A panic fails the job with the panic value and stack trace,
+// instead of crashing the process.
+func (d *Dispatcher) run(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.panics.Add(1)
+			err = fmt.Errorf("pqueue: panic: %v\n%s", r, debug.Stack())
+			d.client.logger.Log(d.ctx, slog.LevelError, "Recovered panic in job", append(d.client.jobAttrs(&job), slog.Any("panic", r))...)
+		}
+	}()
+	return d.worker.Run(ctx, job)
+}
+
+// record writes the result of a job, retrying with backoff.
+func (d *Dispatcher) record(job *Job, runErr error) {
+	backoff := d.statusBackoff
+	var err error
+	var handlerPolicy RetryPolicy
+	if p, ok := d.worker.(retryPolicer); ok {
+		handlerPolicy = p.retryPolicyOf(job.Name)
+	}
+	for i := 0; i < d.statusRetries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-d.ctx.Done():
+				d.handleError(*job, err)
+				return
+			}
+		}
+		if errors.Is(runErr, ErrJobCancelled) {
+			err = d.client.cancelled(d.ctx, job)
+		} else if delay, ok := snoozed(runErr); ok {
+			err = d.client.Snooze(d.ctx, job, delay)
+		} else if runErr != nil {
+			err = d.client.fail(d.ctx, job, runErr, handlerPolicy)
+		} else {
+			err = d.client.Complete(d.ctx, job)
+		}
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrLeaseExpired) {
+			d.client.logger.Log(d.ctx, slog.LevelWarn, "Lost lease of job", d.client.jobAttrs(job)...)
+			return
+		}
+	}
+	d.handleError(*job, err)
 }
 
-func (d *Dispatcher) pop(length int) {
-	jobs, err := LockJobs(length)
+// cancelJobs cancels the contexts of running jobs whose cancel was requested.
+func (d *Dispatcher) cancelJobs() {
+	ids := d.running.ids()
+	if len(ids) == 0 {
+		return
+	}
+	cancelled, err := d.client.cancelRequested(d.ctx, ids)
 	if err != nil {
-		log.Print(err)
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to check cancelled jobs", slog.Any("error", err))
 		return
 	}
+	for _, id := range cancelled {
+		d.running.cancel(id, ErrJobCancelled)
+	}
+}
+
+// cancelledBeforeRun reports whether the cancel of a locked job was requested before it started.
+// Jobs cancelled afterwards are caught by cancelJobs.
+func (d *Dispatcher) cancelledBeforeRun(id int64) bool {
+	cancelled, err := d.client.cancelRequested(d.ctx, []int64{id})
+	if err != nil {
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to check cancelled jobs", slog.Any("error", err))
+		return false
+	}
+	return len(cancelled) > 0
+}
+
+// fill locks jobs for idle workers, and reports whether more jobs may be waiting.
+func (d *Dispatcher) fill() bool {
+	idle := cap(d.sem) - len(d.sem)
+	if idle <= 0 {
+		return true
+	}
+	return d.pop(idle) == idle
+}
+
+// inQueues reports whether the dispatcher processes jobs in the queue.
+func (d *Dispatcher) inQueues(queue string) bool {
+	if len(d.queues) == 0 {
+		return true
+	}
+	for _, q := range d.queues {
+		if q == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// pop locks jobs and passes them to workers. It returns the number of locked jobs.
+func (d *Dispatcher) pop(length int) int {
+	var names []string
+	if n, ok := d.worker.(namer); ok {
+		names = n.Names()
+		if len(names) == 0 {
+			return 0
+		}
+	}
+	jobs, err := d.client.lockJobs(d.ctx, length, d.queues, names, d.lockID, d.leaseTTL)
+	if err != nil {
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to lock jobs", slog.Any("error", err))
+		return 0
+	}
 
 	for _, job := range jobs {
+		// Buffered jobs are held until they start, so their leases are renewed.
+		d.running.add(job.ID, nil)
 		d.jobBuffer <- job
 	}
+	return len(jobs)
 }
@@ -2,7 +2,10 @@ package pqueue
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -89,3 +92,207 @@ func TestStop(t *testing.T) {
 		t.Errorf("processing jobs expect 8, actual %d", len(jobs))
 	}
 }
+
+type blockingWorker struct {
+	canceled chan struct{}
+}
+
+func (w blockingWorker) Run(ctx context.Context, job Job) error {
+	<-ctx.Done()
+	w.canceled <- struct{}{}
+	return ctx.Err()
+}
+
+func TestStopCancelsRunningJobs(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 60)
+	j.Save()
+
+	w := blockingWorker{canceled: make(chan struct{}, 1)}
+	d := NewDispatcher(1, w)
+	d.Start(50)
+	time.Sleep(110 * time.Millisecond)
+
+	ctx, c := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer c()
+	start := time.Now()
+	d.Stop(ctx)
+	if time.Since(start) > time.Second {
+		t.Error("Stop should not wait running jobs after ctx is done")
+	}
+
+	select {
+	case <-w.canceled:
+	case <-time.After(time.Second):
+		t.Error("running job should be canceled by Stop")
+	}
+}
+
+func TestStopHungDatabase(t *testing.T) {
+	TruncateJob()
+
+	tx, err := DefaultClient().DB().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	// Every query of the dispatcher waits for the lock.
+	if _, err = tx.Exec(`LOCK TABLE job IN ACCESS EXCLUSIVE MODE`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(1, worker{})
+	d.Start(10)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, c := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer c()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.Stop(ctx)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(unlockTimeout + time.Second):
+		t.Error("Stop should return after ctx is done while queries hang")
+	}
+}
+
+type closingWorker struct {
+	db *sql.DB
+}
+
+func (w closingWorker) Run(ctx context.Context, job Job) error {
+	return w.db.Close()
+}
+
+func TestErrorHandler(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 5)
+	j.Save()
+
+	c, err := Open(dsn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make(chan Job, 1)
+	d := c.NewDispatcher(1, closingWorker{db: c.DB()},
+		WithStatusRetry(2, 10*time.Millisecond),
+		WithErrorHandler(func(job Job, err error) {
+			failed <- job
+		}),
+	)
+	d.Start(50)
+
+	select {
+	case job := <-failed:
+		if job.ID != j.ID {
+			t.Error("error handler should receive the job")
+		}
+	case <-time.After(time.Second):
+		t.Error("error handler should be called")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d.Stop(ctx)
+	ReleaseJobs()
+}
+
+func TestDispatcherQueues(t *testing.T) {
+	TruncateJob()
+
+	for i := 0; i < 2; i++ {
+		j := NewJob("test", []byte(`{"duration": 10}`), 5, InQueue("report"))
+		j.Save()
+		j = NewJob("test", []byte(`{"duration": 10}`), 5, InQueue("mail"))
+		j.Save()
+	}
+
+	d := NewDispatcher(4, worker{}, WithQueues("mail"))
+	d.Start(50)
+	time.Sleep(200 * time.Millisecond)
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := ProcessedJobs(time.Time{}, 0)
+	if len(jobs) != 2 {
+		t.Errorf("processed jobs expect 2, actual %d", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.Queue != "mail" {
+			t.Errorf("expect queue mail, actual %s", job.Queue)
+		}
+	}
+}
+
+type permanentWorker struct{}
+
+func (w permanentWorker) Run(ctx context.Context, job Job) error {
+	return Permanent(errors.New("malformed payload"))
+}
+
+func TestPermanentErrorFailsJob(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 5)
+	j.Save()
+
+	d := NewDispatcher(1, permanentWorker{})
+	d.Start(50)
+	time.Sleep(110 * time.Millisecond)
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := FailedJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Fatalf("failed jobs expect 1, actual %d", len(jobs))
+	}
+	if jobs[0].RunCount != 1 || jobs[0].LastError != "malformed payload" {
+		t.Errorf("job should fail without retrying, run count %d, last error %q", jobs[0].RunCount, jobs[0].LastError)
+	}
+}
+
+type panickingWorker struct{}
+
+func (w panickingWorker) Run(ctx context.Context, job Job) error {
+	if string(job.Payload) == `{"panic": true}` {
+		panic("boom")
+	}
+	return nil
+}
+
+func TestRecoverPanic(t *testing.T) {
+	TruncateJob()
+
+	for _, p := range []string{`{"panic": true}`, `{"panic": false}`} {
+		j := NewJob("test", []byte(p), 5)
+		j.Save()
+	}
+
+	d := NewDispatcher(1, panickingWorker{})
+	d.Start(50)
+	time.Sleep(200 * time.Millisecond)
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	if d.Panics() != 1 {
+		t.Errorf("panics expect 1, actual %d", d.Panics())
+	}
+	jobs, _ := ProcessedJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Errorf("dispatcher should keep processing after a panic, processed %d", len(jobs))
+	}
+	jobs, _ = FindJobs(ctx, JobFilter{Payload: []byte(`{"panic": true}`)})
+	if len(jobs) != 1 {
+		t.Fatalf("panicking job expect 1, actual %d", len(jobs))
+	}
+	if jobs[0].RunCount != 1 || !strings.Contains(jobs[0].LastError, "panic: boom") || !strings.Contains(jobs[0].LastError, "goroutine") {
+		t.Errorf("panic should fail the job with the stack trace, run count %d, last error %q", jobs[0].RunCount, jobs[0].LastError)
+	}
+}
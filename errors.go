@@ -0,0 +1,32 @@
+package pqueue
+
+import "errors"
+
+// Permanent marks an error which retrying a job never resolves, such as a malformed payload.
+// When Worker.Run returns it, even wrapped, the dispatcher fails the job at once and records
+// the error in LastError. Permanent(nil) is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// permanentError is the error returned by Permanent.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// IsPermanent reports whether any error in the chain of err is marked by Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,27 @@
+package pqueue
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPermanent(t *testing.T) {
+	errBad := errors.New("bad payload")
+	err := fmt.Errorf("handle: %w", Permanent(errBad))
+	if !IsPermanent(err) {
+		t.Error("wrapped permanent error should be permanent")
+	}
+	if !errors.Is(err, errBad) {
+		t.Error("permanent error should unwrap to the cause")
+	}
+	if err.Error() != "handle: bad payload" {
+		t.Errorf("permanent error should keep the message, actual %q", err.Error())
+	}
+	if IsPermanent(errBad) {
+		t.Error("plain errors should not be permanent")
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) should be nil")
+	}
+}
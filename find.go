@@ -0,0 +1,159 @@
+package pqueue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// JobFilter selects jobs in FindJobs. Zero fields match every job.
+type JobFilter struct {
+	Names    []string
+	Queues   []string
+	Statuses []uint
+	// Payload matches jobs whose JSON payload contains it, e.g. {"order_id": 1234}.
+	// Payloads of other codecs never match.
+	Payload json.RawMessage
+	// RunAfterFrom and RunAfterTo limit run_after to [RunAfterFrom, RunAfterTo).
+	RunAfterFrom time.Time
+	RunAfterTo   time.Time
+	// PrevTime and PrevID are RunAfter and ID of the last job of the previous page.
+	PrevTime time.Time
+	PrevID   int64
+	// Limit is the page size. The default is 25.
+	Limit int
+}
+
+// where returns the conditions and arguments of a filter. Arguments are appended to args,
+// which are bound before the conditions.
+func (f JobFilter) where(args []interface{}) (string, []interface{}) {
+	var conds []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(f.Names) > 0 {
+		conds = append(conds, "name = ANY("+arg(pq.StringArray(f.Names))+")")
+	}
+	if len(f.Queues) > 0 {
+		conds = append(conds, "queue = ANY("+arg(pq.StringArray(f.Queues))+")")
+	}
+	if len(f.Statuses) > 0 {
+		statuses := make(pq.Int64Array, len(f.Statuses))
+		for i, s := range f.Statuses {
+			statuses[i] = int64(s)
+		}
+		conds = append(conds, "status = ANY("+arg(statuses)+")")
+	}
+	if len(f.Payload) > 0 {
+		conds = append(conds, "payload @> "+arg(string(f.Payload))+"::jsonb")
+	}
+	if !f.RunAfterFrom.IsZero() {
+		conds = append(conds, "run_after >= "+arg(f.RunAfterFrom))
+	}
+	if !f.RunAfterTo.IsZero() {
+		conds = append(conds, "run_after < "+arg(f.RunAfterTo))
+	}
+	if !f.PrevTime.IsZero() {
+		conds = append(conds, "(run_after, id) < ("+arg(f.PrevTime)+", "+arg(f.PrevID)+")")
+	}
+
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// FindJobs returns jobs matching the filter, ordered by run_after and id descending.
+func (c *Client) FindJobs(ctx context.Context, f JobFilter) ([]Job, error) {
+	if len(f.Payload) > 0 && !json.Valid(f.Payload) {
+		return nil, fmt.Errorf("pqueue: invalid payload filter %s", f.Payload)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 25
+	}
+	where, args := f.where(nil)
+	args = append(args, limit)
+	query := c.stmt(`SELECT `+jobColumns+` FROM {job}`) +
+		where + fmt.Sprintf(" ORDER BY run_after desc, id desc LIMIT $%d", len(args))
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanJobs(rows)
+}
+
+// jobColumns are the columns of a job scanned by scanJob.
+const jobColumns = `id, name, queue, codec, {payload}, status, priority, run_after, timeout, run_count, coalesce(elapsed, 0), coalesce(last_error, ''), coalesce(unique_key, ''), coalesce(max_attempts, 0), coalesce(retry_policy, ''), snooze_count, coalesce(retried_by, ''), retried_at, coalesce(discarded_by, ''), discarded_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (Job, error) {
+	j := Job{}
+	var retriedAt, discardedAt pq.NullTime
+	err := row.Scan(
+		&j.ID,
+		&j.Name,
+		&j.Queue,
+		&j.Codec,
+		&j.Payload,
+		&j.Status,
+		&j.Priority,
+		&j.RunAfter,
+		&j.Timeout,
+		&j.RunCount,
+		&j.Elapsed,
+		&j.LastError,
+		&j.UniqueKey,
+		&j.MaxAttempts,
+		&j.RetryPolicy,
+		&j.SnoozeCount,
+		&j.RetriedBy,
+		&retriedAt,
+		&j.DiscardedBy,
+		&discardedAt,
+	)
+	j.RetriedAt = retriedAt.Time
+	j.DiscardedAt = discardedAt.Time
+	return j, err
+}
+
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// CreatePayloadIndex creates a GIN index on payloads, which speeds up FindJobs by payload.
+// The index is built concurrently, so it runs outside of Migrate.
+func (c *Client) CreatePayloadIndex(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, c.stmt(`CREATE INDEX CONCURRENTLY IF NOT EXISTS "{job_name}_payload_key" ON {job} USING gin (payload jsonb_path_ops)`))
+	return err
+}
+
+// FindJobs returns jobs matching the filter using the default client.
+func FindJobs(ctx context.Context, f JobFilter) ([]Job, error) {
+	return defaultClient.FindJobs(ctx, f)
+}
+
+// CreatePayloadIndex creates a GIN index on payloads of the job table.
+func CreatePayloadIndex(ctx context.Context, db *sql.DB, opts ...ClientOption) error {
+	return NewClient(db, opts...).CreatePayloadIndex(ctx)
+}
@@ -0,0 +1,77 @@
+package pqueue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestJobFilterWhere(t *testing.T) {
+	where, args := JobFilter{}.where(nil)
+	if where != "" || len(args) != 0 {
+		t.Errorf("empty filter should match every job, actual %q", where)
+	}
+
+	where, args = JobFilter{
+		Names:    []string{"mail"},
+		Statuses: []uint{0, 2},
+		Payload:  []byte(`{"order_id":1234}`),
+	}.where(nil)
+	expect := " WHERE name = ANY($1) AND status = ANY($2) AND payload @> $3::jsonb"
+	if where != expect {
+		t.Errorf("expect %q, actual %q", expect, where)
+	}
+	if len(args) != 3 {
+		t.Errorf("args expect 3, actual %d", len(args))
+	}
+}
+
+func TestFindJobs(t *testing.T) {
+	TruncateJob()
+
+	for i := 1; i <= 3; i++ {
+		job := NewJob("receipt", []byte(fmt.Sprintf(`{"order_id": %d, "tags": ["a"]}`, i)), 5)
+		job.RunAfter = time.Now().Add(-time.Duration(i) * time.Hour)
+		job.Save()
+	}
+	job := NewJob("mail", []byte(`{"order_id": 1}`), 5)
+	job.Save()
+
+	ctx := context.Background()
+	jobs, err := FindJobs(ctx, JobFilter{Names: []string{"receipt"}, Payload: []byte(`{"order_id": 1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "receipt" {
+		t.Fatalf("expect the receipt job of order 1, actual %v", jobs)
+	}
+
+	jobs, _ = FindJobs(ctx, JobFilter{Payload: []byte(`{"tags": ["a"]}`), Statuses: []uint{0}, RunAfterFrom: time.Now().Add(-150 * time.Minute)})
+	if len(jobs) != 2 {
+		t.Errorf("expect 2 jobs, actual %d", len(jobs))
+	}
+
+	jobs, _ = FindJobs(ctx, JobFilter{Limit: 2})
+	if len(jobs) != 2 {
+		t.Fatalf("expect 2 jobs, actual %d", len(jobs))
+	}
+	jobs, _ = FindJobs(ctx, JobFilter{Limit: 2, PrevTime: jobs[1].RunAfter, PrevID: jobs[1].ID})
+	if len(jobs) != 2 {
+		t.Errorf("expect 2 jobs, actual %d", len(jobs))
+	}
+
+	if _, err = FindJobs(ctx, JobFilter{Payload: []byte("invalid")}); err == nil {
+		t.Error("invalid payload filter should be an error")
+	}
+}
+
+func TestCreatePayloadIndex(t *testing.T) {
+	ctx := context.Background()
+	if err := CreatePayloadIndex(ctx, DefaultClient().DB()); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreatePayloadIndex(ctx, DefaultClient().DB()); err != nil {
+		t.Error(err)
+	}
+}
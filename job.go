@@ -1,9 +1,8 @@
 package pqueue
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
-	"log"
 	"time"
 
 	validator "gopkg.in/go-playground/validator.v9"
@@ -31,325 +30,224 @@ func init() {
 	validate = validator.New()
 }
 
+// Statuses of a job.
+const (
+	StatusPending   uint = iota // waiting or running
+	StatusProcessed             // completed
+	StatusFailed                // failed after retries
+	StatusCancelled             // cancelled by Cancel
+	StatusDiscarded             // discarded by DiscardJobs
+)
+
 // Job describes a job in a queue.
 type Job struct {
-	ID         int64           `json:"id"`
-	Name       string          `json:"name" validate:"required"`
-	Payload    json.RawMessage `json:"payload,omitempty"`
-	Status     uint            `json:"status" validate:"gte=0,lte=2"` // 0 yet, 1 processed, 2 failed
-	Priority   int             `json:"priority"`
-	RunAfter   time.Time       `json:"run_after"`
-	Timeout    uint            `json:"time_out" validate:"gt=0"`
-	RunCount   uint            `json:"run_count"`
-	RetryDelay uint            `json:"retry_delay"` // second
-	Elapsed    float64         `json:"elapsed"`
-	LastError  string          `json:"last_error"`
+	ID          int64           `json:"id"`
+	Name        string          `json:"name" validate:"required"`
+	Queue       string          `json:"queue"`
+	Codec       string          `json:"codec"` // name of the Codec of Payload
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      uint            `json:"status" validate:"gte=0,lte=2"` // 0 yet, 1 processed, 2 failed, 3 cancelled, 4 discarded
+	Priority    int             `json:"priority"`
+	RunAfter    time.Time       `json:"run_after"`
+	Timeout     uint            `json:"time_out" validate:"gt=0"`
+	RunCount    uint            `json:"run_count"`
+	RetryDelay  uint            `json:"retry_delay"` // second
+	Elapsed     float64         `json:"elapsed"`
+	LastError   string          `json:"last_error"`
+	UniqueKey   string          `json:"unique_key,omitempty" validate:"max=255"`
+	MaxAttempts uint            `json:"max_attempts" validate:"lte=32767"`        // runs before the job fails
+	RetryPolicy string          `json:"retry_policy,omitempty" validate:"max=64"` // name of a policy registered by WithNamedRetryPolicy
+	SnoozeCount uint            `json:"snooze_count"`                             // runs which snoozed the job, not counted in RunCount
+	// who and when last retried the job by RetryJob or RetryFailedJobs, or discarded it
+	RetriedBy   string    `json:"retried_by,omitempty"`
+	RetriedAt   time.Time `json:"retried_at"`
+	DiscardedBy string    `json:"discarded_by,omitempty"`
+	DiscardedAt time.Time `json:"discarded_at"`
+	LockedBy    string    `json:"locked_by,omitempty"` // ID of the worker running the job, see ListWorkers
+
+	uniqueByPayload bool
+	onDuplicate     DuplicateAction
+	startedAt       time.Time // start of the run by a dispatcher, recorded in its attempt
 }
 
-// NewJob creates a job. NOTE: timeout should be greater than 0.
-func NewJob(name string, payload json.RawMessage, timeout uint) Job {
-	return Job{
-		Name:       name,
-		Payload:    payload,
-		Status:     0, // yet
-		Priority:   0,
-		RunAfter:   time.Now(),
-		Timeout:    timeout,
-		RunCount:   0,
-		RetryDelay: jobConfig.RetryDelay,
+// DefaultQueue is the queue of jobs which are not given a queue.
+const DefaultQueue = "default"
+
+// JobOption configures a job created by NewJob.
+type JobOption func(*Job)
+
+// InQueue puts a job in the queue.
+func InQueue(queue string) JobOption {
+	return func(j *Job) {
+		j.Queue = queue
 	}
 }
 
-// Save inserts a job.
-func (j *Job) Save() error {
-	err := validate.Struct(j)
-	if err != nil {
-		return err
+// WithCodec sets the name of the codec which encoded the payload of a job.
+// By default the codec of the client saving the job is used.
+func WithCodec(name string) JobOption {
+	return func(j *Job) {
+		j.Codec = name
 	}
-	var payload interface{}
-	if len(j.Payload) > 0 {
-		err = json.Unmarshal(j.Payload, &payload)
-		if err != nil {
-			return err
-		}
+}
+
+// WithTimeout sets the timeout of a job in seconds.
+func WithTimeout(seconds uint) JobOption {
+	return func(j *Job) {
+		j.Timeout = seconds
 	}
+}
 
-	stmt, err := db.Prepare(`INSERT INTO "job" (name,payload,status,priority,run_after,timeout,run_count,retry_delay,last_error) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,'') RETURNING id`)
-	if err != nil {
-		return err
+// WithPriority sets the priority of a job. Large number is low latency.
+func WithPriority(priority int) JobOption {
+	return func(j *Job) {
+		j.Priority = priority
 	}
+}
+
+// RunAt schedules a job.
+func RunAt(t time.Time) JobOption {
+	return func(j *Job) {
+		j.RunAfter = t
+	}
+}
+
+// NewJob creates a job. NOTE: timeout should be greater than 0.
+func NewJob(name string, payload json.RawMessage, timeout uint, opts ...JobOption) Job {
+	j := Job{
+		Name:        name,
+		Queue:       DefaultQueue,
+		Payload:     payload,
+		Status:      StatusPending,
+		Priority:    0,
+		RunAfter:    time.Now(),
+		Timeout:     timeout,
+		RunCount:    0,
+		RetryDelay:  jobConfig.RetryDelay,
+		MaxAttempts: jobConfig.MaxRetryCount,
+	}
+	for _, opt := range opts {
+		opt(&j)
+	}
+	return j
+}
+
+// Save inserts a job using the default client.
+func (j *Job) Save() error {
+	return j.SaveContext(context.Background())
+}
+
+// SaveContext inserts a job using the default client.
+func (j *Job) SaveContext(ctx context.Context) error {
+	return defaultClient.Save(ctx, j)
+}
 
-	err = stmt.QueryRow(
-		j.Name,
-		j.Payload,
-		j.Status,
-		j.Priority,
-		j.RunAfter,
-		j.Timeout,
-		j.RunCount,
-		j.RetryDelay,
-	).Scan(&j.ID)
-	return err
+// SaveTx inserts a job within a transaction using the default client.
+func (j *Job) SaveTx(ctx context.Context, tx Querier) error {
+	return defaultClient.SaveTx(ctx, tx, j)
 }
 
-// Delete removes a job.
+// Delete removes a job using the default client.
 func (j *Job) Delete() error {
-	stmt, err := db.Prepare(`DELETE FROM "job" WHERE id = $1 RETURNING id`)
-	if err != nil {
-		return err
-	}
+	return j.DeleteContext(context.Background())
+}
 
-	var id int64
-	err = stmt.QueryRow(j.ID).Scan(&id)
-	if err != nil {
-		return err
-	}
-	return nil
+// DeleteContext removes a job using the default client.
+func (j *Job) DeleteContext(ctx context.Context) error {
+	return defaultClient.Delete(ctx, j)
 }
 
-// LockJobs locks rows using advisory lock and returns jobs.
-func LockJobs(length int) ([]Job, error) {
-	rows, err := db.Query(`UPDATE "job" SET grabbed = now() WHERE id IN (SELECT id FROM (SELECT id FROM "job" WHERE grabbed is NULL AND run_after <= now() AND status = 0 ORDER BY priority desc LIMIT $1) potential_jobs WHERE pg_try_advisory_lock(id)) AND grabbed is NULL RETURNING id, name, payload, run_after, timeout, run_count, retry_delay`, length)
+// Complete done a job using the default client.
+func (j *Job) Complete() error {
+	return j.CompleteContext(context.Background())
+}
 
-	defer rows.Close()
+// CompleteContext done a job using the default client.
+func (j *Job) CompleteContext(ctx context.Context) error {
+	return defaultClient.Complete(ctx, j)
+}
 
-	if err != nil {
-		return nil, err
-	}
+// Fail re-queues a job, or makes failed status using the default client.
+func (j *Job) Fail(errStr string) error {
+	return j.FailContext(context.Background(), errStr)
+}
 
-	var jobs []Job
-	for rows.Next() {
-		j := Job{}
-		err := rows.Scan(
-			&j.ID,
-			&j.Name,
-			&j.Payload,
-			&j.RunAfter,
-			&j.Timeout,
-			&j.RunCount,
-			&j.RetryDelay,
-		)
-		if err != nil {
-			return nil, err
-		}
-		jobs = append(jobs, j)
-	}
-	return jobs, nil
+// FailContext re-queues a job, or makes failed status using the default client.
+func (j *Job) FailContext(ctx context.Context, errStr string) error {
+	return defaultClient.Fail(ctx, j, errStr)
 }
 
-// UnlockJobs unlocks rows about
-func UnlockJobs() error {
-	_, err := db.Exec(`UPDATE "job" SET grabbed = null WHERE id IN (SELECT id FROM (SELECT id FROM "job" WHERE grabbed is NOT NULL AND run_after <= now() AND status = 0) potential_jobs WHERE pg_advisory_unlock(id)) AND grabbed is NOT NULL`)
-	return err
+// EnqueueMany inserts jobs in batches using the default client.
+func EnqueueMany(ctx context.Context, jobs []Job) ([]int64, error) {
+	return defaultClient.EnqueueMany(ctx, jobs)
 }
 
-// ReleaseJobs set grabbed = null, which status = 0
-func ReleaseJobs() error {
-	_, err := db.Query(`UPDATE "job" SET grabbed = null WHERE id IN (SELECT id FROM (SELECT id FROM "job" WHERE grabbed is NOT NULL AND run_after <= now() AND status = 0) potential_jobs WHERE pg_try_advisory_lock(id)) AND grabbed IS NOT NULL`)
-	return err
+// LockJobs locks rows and returns jobs using the default client.
+func LockJobs(length int, queues ...string) ([]Job, error) {
+	return LockJobsContext(context.Background(), length, queues...)
 }
 
-// Complete done a job, or re-queue a job if failed
-func (j *Job) Complete() {
-	stmt, err := db.Prepare(`UPDATE "job" SET status = 1, run_count = $2, elapsed = $3 WHERE ID = $1 RETURNING pg_advisory_unlock($1)`)
-	if err != nil {
-		log.Print(err)
-		return
-	}
+// LockJobsContext locks rows and returns jobs using the default client.
+func LockJobsContext(ctx context.Context, length int, queues ...string) ([]Job, error) {
+	return defaultClient.LockJobs(ctx, length, queues...)
+}
 
-	_, err = stmt.Exec(j.ID, j.RunCount+1, j.Elapsed)
-	if err != nil {
-		log.Print(err)
-		return
-	}
-	j.Status = 1
-	j.RunCount++
-
-	log.Printf("Processed job id: %d, name: %s, payload: %s", j.ID, j.Name, j.Payload)
-}
-
-// Fail re-queues a job, or makes failed status if run count greater than max retries.
-func (j *Job) Fail(errStr string) {
-	runCount := j.RunCount + 1
-
-	if runCount >= jobConfig.MaxRetryCount {
-		stmt, err := db.Prepare(`UPDATE "job" SET status = 2, run_count = $2, elapsed = $3, last_error = $4 WHERE id = $1 RETURNING pg_advisory_unlock($1)`)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-
-		_, err = stmt.Exec(j.ID, runCount, j.Elapsed, errStr)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		j.Status = 2
-	} else {
-		delay := runCount*runCount*runCount*runCount + j.Timeout + j.RetryDelay + 15
-		stmt, err := db.Prepare(`UPDATE "job" SET run_count = $2, retry_delay = $3, run_after = $4, elapsed = $5, last_error = $6, grabbed = null WHERE id = $1 RETURNING pg_advisory_unlock($1)`)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-
-		_, err = stmt.Exec(
-			j.ID,
-			runCount,
-			delay,
-			j.RunAfter.Add(time.Duration(delay)*time.Second),
-			j.Elapsed,
-			errStr,
-		)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		j.RetryDelay = delay
-		j.RunAfter = j.RunAfter.Add(time.Duration(delay) * time.Second)
-	}
-	j.RunCount++
-	log.Printf("Failed job id: %d, name: %s, payload: %s", j.ID, j.Name, j.Payload)
+// UnlockJobs unlocks rows using the default client.
+func UnlockJobs() error {
+	return UnlockJobsContext(context.Background())
 }
 
-// EnqueuedJobsByName returns jobs, specific job is not run yet.
-func EnqueuedJobsByName(name string) ([]Job, error) {
-	query := `SELECT id, name, payload, status, priority, run_after, timeout, run_count FROM "job" WHERE run_after > now() and name = $1 ORDER BY run_after desc, id desc`
+// UnlockJobsContext unlocks rows using the default client.
+func UnlockJobsContext(ctx context.Context) error {
+	return defaultClient.UnlockJobs(ctx)
+}
 
-	rows, err := db.Query(query, name)
-	defer rows.Close()
+// ReleaseJobs releases locked jobs using the default client.
+func ReleaseJobs() error {
+	return ReleaseJobsContext(context.Background())
+}
 
-	if err != nil {
-		return nil, err
-	}
+// ReleaseJobsContext releases locked jobs using the default client.
+func ReleaseJobsContext(ctx context.Context) error {
+	return defaultClient.ReleaseJobs(ctx)
+}
 
-	var jobs []Job
-	for rows.Next() {
-		j := Job{}
-		err := rows.Scan(
-			&j.ID,
-			&j.Name,
-			&j.Payload,
-			&j.Status,
-			&j.Priority,
-			&j.RunAfter,
-			&j.Timeout,
-			&j.RunCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-		jobs = append(jobs, j)
-	}
-	return jobs, nil
+// EnqueuedJobsByName returns jobs, specific job is not run yet, using the default client.
+func EnqueuedJobsByName(name string) ([]Job, error) {
+	return EnqueuedJobsByNameContext(context.Background(), name)
 }
 
-// ProcessingJobs returns jobs, which status is done
-func ProcessingJobs() ([]Job, error) {
-	rows, err := db.Query(`SELECT id, name, payload, status, priority, run_after, timeout, run_count FROM "job" WHERE status = 0 AND grabbed is not null`)
-	defer rows.Close()
+// EnqueuedJobsByNameContext returns jobs, specific job is not run yet, using the default client.
+func EnqueuedJobsByNameContext(ctx context.Context, name string) ([]Job, error) {
+	return defaultClient.EnqueuedJobsByName(ctx, name)
+}
 
-	if err != nil {
-		return nil, err
-	}
+// ProcessingJobs returns processing jobs using the default client.
+func ProcessingJobs() ([]Job, error) {
+	return ProcessingJobsContext(context.Background())
+}
 
-	var jobs []Job
-	for rows.Next() {
-		j := Job{}
-		err := rows.Scan(
-			&j.ID,
-			&j.Name,
-			&j.Payload,
-			&j.Status,
-			&j.Priority,
-			&j.RunAfter,
-			&j.Timeout,
-			&j.RunCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-		jobs = append(jobs, j)
-	}
-	return jobs, nil
+// ProcessingJobsContext returns processing jobs using the default client.
+func ProcessingJobsContext(ctx context.Context) ([]Job, error) {
+	return defaultClient.ProcessingJobs(ctx)
 }
 
-// ProcessedJobs returns jobs, which status is done
+// ProcessedJobs returns processed jobs using the default client.
 func ProcessedJobs(prevTime time.Time, prevID int64) ([]Job, error) {
-	var rows *sql.Rows
-	var err error
-	if prevTime.IsZero() {
-		query := `SELECT id, name, payload, status, priority, run_after, timeout, run_count, elapsed, last_error FROM "job" WHERE status = 1 ORDER BY run_after desc, id desc limit 25`
-		rows, err = db.Query(query)
-	} else {
-		query := `SELECT id, name, payload, status, priority, run_after, timeout, run_count, elapsed, last_error FROM "job" WHERE status = 1 AND (run_after, id) < ($1, $2) ORDER BY run_after desc, id desc limit 25`
-		rows, err = db.Query(query, prevTime, prevID)
-	}
-	defer rows.Close()
-
-	if err != nil {
-		return nil, err
-	}
+	return ProcessedJobsContext(context.Background(), prevTime, prevID)
+}
 
-	var jobs []Job
-	for rows.Next() {
-		j := Job{}
-		err := rows.Scan(
-			&j.ID,
-			&j.Name,
-			&j.Payload,
-			&j.Status,
-			&j.Priority,
-			&j.RunAfter,
-			&j.Timeout,
-			&j.RunCount,
-			&j.Elapsed,
-			&j.LastError,
-		)
-		if err != nil {
-			return nil, err
-		}
-		jobs = append(jobs, j)
-	}
-	return jobs, nil
+// ProcessedJobsContext returns processed jobs using the default client.
+func ProcessedJobsContext(ctx context.Context, prevTime time.Time, prevID int64) ([]Job, error) {
+	return defaultClient.ProcessedJobs(ctx, prevTime, prevID)
 }
 
-// FailedJobs returns jobs, which status is failed
+// FailedJobs returns failed jobs using the default client.
 func FailedJobs(prevTime time.Time, prevID int64) ([]Job, error) {
-	var rows *sql.Rows
-	var err error
-	if prevTime.IsZero() {
-		query := `SELECT id, name, payload, status, priority, run_after, timeout, run_count, elapsed, last_error FROM "job" WHERE status = 2 ORDER BY run_after desc, id desc limit 25`
-		rows, err = db.Query(query)
-	} else {
-		query := `SELECT id, name, payload, status, priority, run_after, timeout, run_count, elapsed, last_error FROM "job" WHERE status = 2 AND (run_after, id) < ($1, $2) ORDER BY run_after desc, id desc limit 25`
-		rows, err = db.Query(query, prevTime, prevID)
-	}
-	defer rows.Close()
-
-	if err != nil {
-		return nil, err
-	}
+	return FailedJobsContext(context.Background(), prevTime, prevID)
+}
 
-	var jobs []Job
-	for rows.Next() {
-		j := Job{}
-		err := rows.Scan(
-			&j.ID,
-			&j.Name,
-			&j.Payload,
-			&j.Status,
-			&j.Priority,
-			&j.RunAfter,
-			&j.Timeout,
-			&j.RunCount,
-			&j.Elapsed,
-			&j.LastError,
-		)
-		if err != nil {
-			return nil, err
-		}
-		jobs = append(jobs, j)
-	}
-	return jobs, nil
+// FailedJobsContext returns failed jobs using the default client.
+func FailedJobsContext(ctx context.Context, prevTime time.Time, prevID int64) ([]Job, error) {
+	return defaultClient.FailedJobs(ctx, prevTime, prevID)
 }
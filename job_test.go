@@ -1,6 +1,7 @@
 package pqueue
 
 import (
+	"context"
 	"strings"
 	"testing"
 	"time"
@@ -266,3 +267,147 @@ func TestFailedJob(t *testing.T) {
 		t.Errorf("expect timeout 1, actual %d", jobs[0].Timeout)
 	}
 }
+
+func TestJobSaveTx(t *testing.T) {
+	TruncateJob()
+
+	ctx := context.Background()
+	tx, err := DefaultClient().DB().BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := NewJob("test", []byte(`{"test":"job"}`), 5)
+	if err = job.SaveTx(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == 0 {
+		t.Error("Job.ID should be set")
+	}
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 0 {
+		t.Errorf("uncommitted jobs should not be locked, actual %d", len(jobs))
+	}
+	tx.Rollback()
+
+	tx, err = DefaultClient().DB().BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = NewJob("test", []byte(`{"test":"job"}`), 5)
+	if err = job.SaveTx(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ = LockJobs(1)
+	if len(jobs) != 1 {
+		t.Errorf("committed jobs expect 1, actual %d", len(jobs))
+	}
+}
+
+func TestEnqueueMany(t *testing.T) {
+	TruncateJob()
+
+	jobs := make([]Job, 2500)
+	for i := range jobs {
+		jobs[i] = NewJob("test", []byte(`{"test":"job"}`), uint(i%100+1))
+	}
+	ids, err := EnqueueMany(context.Background(), jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(jobs) {
+		t.Fatalf("ids expect %d, actual %d", len(jobs), len(ids))
+	}
+	for i := range jobs {
+		if jobs[i].ID != ids[i] {
+			t.Fatalf("Job.ID expect %d, actual %d", ids[i], jobs[i].ID)
+		}
+		if i > 0 && ids[i] <= ids[i-1] {
+			t.Fatal("ids should be in the order of jobs")
+		}
+	}
+
+	jobs, _ = LockJobs(3000)
+	if len(jobs) != 2500 {
+		t.Errorf("locked jobs expect 2500, actual %d", len(jobs))
+	}
+}
+
+func TestEnqueueManyValidatesAllJobs(t *testing.T) {
+	TruncateJob()
+
+	jobs := []Job{
+		NewJob("test", nil, 5),
+		NewJob("test", []byte("invalid"), 5),
+	}
+	_, err := EnqueueMany(context.Background(), jobs)
+	if err == nil {
+		t.Error("EnqueueMany should validate payloads")
+	}
+	if jobs[0].ID != 0 {
+		t.Error("no jobs should be inserted")
+	}
+	locked, _ := LockJobs(2)
+	if len(locked) != 0 {
+		t.Errorf("locked jobs expect 0, actual %d", len(locked))
+	}
+}
+
+func TestCompleteJobReturnsError(t *testing.T) {
+	TruncateJob()
+
+	job := NewJob("test", nil, 5)
+	job.Save()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := job.CompleteContext(ctx); err == nil {
+		t.Error("CompleteContext should return the error")
+	}
+	if job.Status != 0 {
+		t.Error("Job.Status should be 0")
+	}
+	if err := job.FailContext(ctx, ""); err == nil {
+		t.Error("FailContext should return the error")
+	}
+	if job.RunCount != 0 {
+		t.Error("Job.RunCount should be 0")
+	}
+}
+
+func TestNewJobInQueue(t *testing.T) {
+	job := NewJob("test", nil, 5)
+	if job.Queue != DefaultQueue {
+		t.Errorf("expect queue %s, actual %s", DefaultQueue, job.Queue)
+	}
+	job = NewJob("test", nil, 5, InQueue("mail"))
+	if job.Queue != "mail" {
+		t.Errorf("expect queue mail, actual %s", job.Queue)
+	}
+}
+
+func TestLockJobsByQueue(t *testing.T) {
+	TruncateJob()
+
+	for _, q := range []string{"mail", "report", "mail", DefaultQueue} {
+		job := NewJob("test", nil, 5, InQueue(q))
+		job.Save()
+	}
+
+	jobs, _ := LockJobs(5, "mail")
+	if len(jobs) != 2 {
+		t.Errorf("locked jobs expect 2, actual %d", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.Queue != "mail" {
+			t.Errorf("expect queue mail, actual %s", job.Queue)
+		}
+	}
+
+	jobs, _ = LockJobs(5)
+	if len(jobs) != 2 {
+		t.Errorf("locked jobs expect 2, actual %d", len(jobs))
+	}
+}
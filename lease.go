@@ -0,0 +1,156 @@
+package pqueue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// defaultLeaseTTL is how long a job stays locked without heartbeats.
+const defaultLeaseTTL = 30 * time.Second
+
+// ErrLeaseExpired is the cause of the context of a job whose lease expired and was re-queued.
+// The result of such a job is not recorded, since another dispatcher may run it already.
+// Complete, Fail and Snooze return it for such a job.
+var ErrLeaseExpired = errors.New("pqueue: lease of job expired")
+
+// heldBy is the condition of status updates by the worker of a bind parameter, which match
+// the job only while the worker holds its lease. Jobs never locked match an empty worker.
+func heldBy(worker string) string {
+	return ` AND locked_by IS NOT DISTINCT FROM NULLIF(` + worker + `, '')`
+}
+
+// leaseHeld returns ErrLeaseExpired if a status update by heldBy matched no job.
+func leaseHeld(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// WithLease sets how long jobs of a dispatcher stay locked without heartbeats.
+// The dispatcher renews the lease every third of ttl, and re-queues jobs of any dispatcher
+// whose lease expired. The lease is stored with each job, so dispatchers with different
+// leases may share a table. The default is 30 seconds.
+func WithLease(ttl time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		if ttl > 0 {
+			d.leaseTTL = ttl
+		}
+	}
+}
+
+// heartbeat renews the lease of pending jobs of ids locked by lockedBy for ttl, and returns
+// the ids of the renewed jobs.
+func (c *Client) heartbeat(ctx context.Context, lockedBy string, ttl time.Duration, ids []int64) ([]int64, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`UPDATE {job} SET heartbeat_at = now(), lease_expires_at = now() + make_interval(secs => $2) WHERE status = 0 AND locked_by = $1 AND id = ANY($3) RETURNING id`), lockedBy, ttl.Seconds(), pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var renewed []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		renewed = append(renewed, id)
+	}
+	return renewed, rows.Err()
+}
+
+// ReapJobs re-queues jobs whose lease expired, because their dispatcher died. The lease is stored
+// with each job by the dispatcher which locked it, and ttl is only the lease of jobs locked
+// before leases were stored. It counts as an attempt, so a job fails after the max retry count
+// like a failed run. Jobs whose cancel was requested are cancelled. It returns the ids of the
+// reaped jobs. Dispatchers reap jobs by themselves, so calling it is needed only without dispatchers.
+func (c *Client) ReapJobs(ctx context.Context, ttl time.Duration) ([]int64, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`WITH expired AS (SELECT id, locked_by, locked_at FROM {job} WHERE status = 0 AND locked_at IS NOT NULL AND coalesce(lease_expires_at, coalesce(heartbeat_at, locked_at) + make_interval(secs => $3)) < now() FOR UPDATE SKIP LOCKED), `+
+		`updated AS (UPDATE {job} AS j SET locked_at = null, locked_by = null, heartbeat_at = null, lease_expires_at = null, run_count = run_count + 1, last_error = $1, status = CASE WHEN cancelled_at IS NOT NULL THEN 3 WHEN run_count + 1 >= coalesce(max_attempts, $2) THEN 2 ELSE 0 END FROM expired WHERE j.id = expired.id RETURNING j.id, j.run_count, expired.locked_by, expired.locked_at) `+
+		`INSERT INTO {attempts} (job_id, attempt, worker_id, started_at, finished_at, duration, outcome, error) SELECT id, run_count, locked_by, locked_at, now(), extract(epoch FROM now() - locked_at), '`+AttemptLeaseExpired+`', $1 FROM updated RETURNING job_id`), ErrLeaseExpired.Error(), jobConfig.MaxRetryCount, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		c.logger.Log(ctx, slog.LevelWarn, "Reaped jobs", slog.Any("job_ids", pq.Int64Array(ids)))
+	}
+	return ids, nil
+}
+
+// ReapJobs re-queues jobs whose lease expired using the default client.
+func ReapJobs(ctx context.Context, ttl time.Duration) ([]int64, error) {
+	return defaultClient.ReapJobs(ctx, ttl)
+}
+
+// keepLeases renews the leases of running jobs and the registration of the dispatcher,
+// and reaps expired ones until the dispatcher stops.
+func (d *Dispatcher) keepLeases() {
+	ticker := time.NewTicker(d.leaseTTL / 3)
+	defer ticker.Stop()
+	reapTicker := time.NewTicker(d.leaseTTL)
+	defer reapTicker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.renewLeases()
+			d.touch()
+		case <-reapTicker.C:
+			if _, err := d.client.ReapJobs(d.ctx, d.leaseTTL); err != nil {
+				d.client.logger.Log(d.ctx, slog.LevelError, "Failed to reap jobs", slog.Any("error", err))
+			}
+			d.prune()
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// renewLeases heartbeats jobs held by the dispatcher, and cancels running jobs which were reaped.
+func (d *Dispatcher) renewLeases() {
+	// Only jobs the dispatcher still holds are renewed. A job whose result could not be recorded
+	// is left to expire, and reaped like a job of a dead dispatcher.
+	running := d.running.ids()
+	if len(running) == 0 {
+		return
+	}
+	ids, err := d.client.heartbeat(d.ctx, d.lockID, d.leaseTTL, running)
+	if err != nil {
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to renew leases", slog.Any("error", err))
+		return
+	}
+	held := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		held[id] = true
+	}
+	for _, id := range running {
+		if !held[id] {
+			d.running.cancel(id, ErrLeaseExpired)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReapJobs(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	orphan := NewJob("test", nil, 5)
+	orphan.Save()
+	last := NewJob("test", nil, 5)
+	last.RunCount = jobConfig.MaxRetryCount - 1
+	last.Save()
+	alive := NewJob("test", nil, 5)
+	alive.Save()
+	LockJobs(3)
+	DefaultClient().DB().Exec(`UPDATE job SET lease_expires_at = now() - interval '1 second' WHERE id IN ($1, $2)`, orphan.ID, last.ID)
+
+	// The lease of each job decides, not ttl.
+	ids, err := ReapJobs(ctx, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("reaped jobs expect 2, actual %d", len(ids))
+	}
+
+	attempts, _ := JobAttempts(ctx, orphan.ID)
+	if len(attempts) != 1 || attempts[0].Outcome != AttemptLeaseExpired || attempts[0].WorkerID != DefaultClient().lockID {
+		t.Errorf("reaped job should record an attempt, %+v", attempts)
+	}
+
+	jobs, _ := FindJobs(ctx, JobFilter{Statuses: []uint{StatusPending}})
+	for _, j := range jobs {
+		switch j.ID {
+		case orphan.ID:
+			if j.RunCount != 1 || j.LastError != ErrLeaseExpired.Error() {
+				t.Errorf("reaped job should count an attempt, run count %d, last error %q", j.RunCount, j.LastError)
+			}
+		case last.ID:
+			t.Error("reaped job should fail after the max retry count")
+		}
+	}
+	jobs, _ = ProcessingJobs()
+	if len(jobs) != 1 || jobs[0].ID != alive.ID {
+		t.Error("jobs with a live lease should stay locked")
+	}
+	jobs, _ = LockJobs(3)
+	if len(jobs) != 1 || jobs[0].ID != orphan.ID {
+		t.Error("reaped job should be locked again")
+	}
+}
+
+func TestDispatcherLostLease(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 60)
+	j.Save()
+
+	w := blockingWorker{canceled: make(chan struct{}, 1)}
+	d := NewDispatcher(1, w, WithLease(600*time.Millisecond))
+	d.Start(50)
+	time.Sleep(110 * time.Millisecond)
+
+	// Another dispatcher took over the job after its lease expired.
+	DefaultClient().DB().Exec(`UPDATE job SET locked_by = 'other' WHERE id = $1`, j.ID)
+	select {
+	case <-w.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("job whose lease expired should be canceled")
+	}
+
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := ProcessingJobs()
+	if len(jobs) != 1 || jobs[0].LockedBy != "other" {
+		t.Error("result of a job whose lease expired should not be recorded")
+	}
+}
+
+func TestCompleteLostLease(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	j := NewJob("test", nil, 5)
+	j.Save()
+	jobs, _ := LockJobs(1)
+	if len(jobs) != 1 {
+		t.Fatalf("locked jobs expect 1, actual %d", len(jobs))
+	}
+	// The job was reaped and locked by another dispatcher.
+	DefaultClient().DB().Exec(`UPDATE job SET locked_by = 'other' WHERE id = $1`, j.ID)
+
+	if err := jobs[0].CompleteContext(ctx); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("expect ErrLeaseExpired, actual %v", err)
+	}
+	if err := jobs[0].FailContext(ctx, "boom"); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("expect ErrLeaseExpired, actual %v", err)
+	}
+	jobs, _ = ProcessingJobs()
+	if len(jobs) != 1 || jobs[0].LockedBy != "other" {
+		t.Error("job of another dispatcher should stay locked")
+	}
+
+	if err := jobs[0].CompleteContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var unlocked bool
+	DefaultClient().DB().QueryRow(`SELECT locked_at IS NULL AND locked_by IS NULL FROM job WHERE id = $1`, j.ID).Scan(&unlocked)
+	if !unlocked {
+		t.Error("finished jobs should be unlocked")
+	}
+}
+
+func TestReapUnrecordedJob(t *testing.T) {
+	TruncateJob()
+	db := DefaultClient().DB()
+
+	// Completing jobs fails, so the dispatcher gives up recording the result.
+	db.Exec(`CREATE OR REPLACE FUNCTION pqueue_test_fail() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'boom'; END $$ LANGUAGE plpgsql`)
+	db.Exec(`CREATE TRIGGER pqueue_test_fail BEFORE UPDATE ON job FOR EACH ROW WHEN (NEW.status = 1) EXECUTE PROCEDURE pqueue_test_fail()`)
+	defer db.Exec(`DROP FUNCTION pqueue_test_fail() CASCADE`)
+
+	j := NewJob("test", []byte(`{"duration": 0}`), 5)
+	j.RunCount = jobConfig.MaxRetryCount - 1
+	j.Save()
+
+	failed := make(chan Job, 1)
+	d := NewDispatcher(1, worker{},
+		WithLease(600*time.Millisecond),
+		WithStatusRetry(1, 0),
+		WithErrorHandler(func(job Job, err error) {
+			failed <- job
+		}),
+	)
+	d.Start(50)
+	defer func() {
+		ctx, c := context.WithTimeout(context.Background(), time.Second)
+		defer c()
+		d.Stop(ctx)
+	}()
+
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher should give up recording the job")
+	}
+
+	// The job is not renewed any more, so it is reaped like a job of a dead dispatcher.
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		jobs, _ := FailedJobs(time.Time{}, 0)
+		if len(jobs) == 1 {
+			if jobs[0].LastError != ErrLeaseExpired.Error() {
+				t.Errorf("expect last error %q, actual %q", ErrLeaseExpired.Error(), jobs[0].LastError)
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("job whose result was not recorded should be reaped")
+}
@@ -0,0 +1,42 @@
+package pqueue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Logger writes structured logs of a client and its dispatchers.
+// *slog.Logger satisfies it, so any slog.Handler can be used through slog.New.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// PayloadLogging controls how job payloads appear in logs.
+type PayloadLogging int
+
+const (
+	// LogPayload writes payloads as they are.
+	LogPayload PayloadLogging = iota
+	// RedactPayload writes only the size of payloads.
+	RedactPayload
+	// OmitPayload writes no payload field.
+	OmitPayload
+)
+
+// jobAttrs returns structured fields describing a job.
+func (c *Client) jobAttrs(j *Job) []any {
+	args := []any{
+		slog.Int64("job_id", j.ID),
+		slog.String("name", j.Name),
+		slog.Uint64("run_count", uint64(j.RunCount)),
+		slog.Float64("elapsed", j.Elapsed),
+	}
+	switch c.payloadLogging {
+	case LogPayload:
+		args = append(args, slog.String("payload", string(j.Payload)))
+	case RedactPayload:
+		args = append(args, slog.String("payload", fmt.Sprintf("[redacted %d bytes]", len(j.Payload))))
+	}
+	return args
+}
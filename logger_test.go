@@ -0,0 +1,40 @@
+package pqueue
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPayloadLogging(t *testing.T) {
+	job := NewJob("test", []byte(`{"email":"someone@example.com"}`), 5)
+	job.ID = 1
+
+	tests := []struct {
+		mode   PayloadLogging
+		expect string
+	}{
+		{LogPayload, `payload="{\"email\":\"someone@example.com\"}"`},
+		{RedactPayload, `payload="[redacted 31 bytes]"`},
+		{OmitPayload, ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		c := NewClient(nil, WithLogger(slog.New(slog.NewTextHandler(&buf, nil))), WithPayloadLogging(tt.mode))
+		c.logger.Log(context.Background(), slog.LevelInfo, "Processed job", c.jobAttrs(&job)...)
+
+		out := buf.String()
+		if !strings.Contains(out, "job_id=1 name=test run_count=0") {
+			t.Errorf("expect job fields, actual %s", out)
+		}
+		if tt.expect == "" {
+			if strings.Contains(out, "payload") {
+				t.Errorf("expect no payload, actual %s", out)
+			}
+		} else if !strings.Contains(out, tt.expect) {
+			t.Errorf("expect %s, actual %s", tt.expect, out)
+		}
+	}
+}
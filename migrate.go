@@ -0,0 +1,127 @@
+package pqueue
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Migrations are named <version>_<description>.sql and applied in version order.
+// {job} in a migration is replaced with the job table, and {job_name} with its bare name.
+//
+//go:embed data/migrations/*.sql
+var migrationFiles embed.FS
+
+const migrationsTable = "pqueue_schema_migrations"
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("data/migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []migration
+	for _, e := range entries {
+		name := e.Name()
+		i := strings.IndexByte(name, '_')
+		if i < 0 {
+			return nil, fmt.Errorf("pqueue: invalid migration name %s", name)
+		}
+		version, err := strconv.Atoi(name[:i])
+		if err != nil {
+			return nil, fmt.Errorf("pqueue: invalid migration name %s", name)
+		}
+		b, err := migrationFiles.ReadFile(path.Join("data/migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, migration{version: version, name: name, sql: string(b)})
+	}
+	sort.Slice(ms, func(a, b int) bool { return ms[a].version < ms[b].version })
+	return ms, nil
+}
+
+// Migrate applies pending migrations of the job table.
+// Use WithSchema and WithTable to migrate a table other than job.
+func Migrate(ctx context.Context, db *sql.DB, opts ...ClientOption) error {
+	return NewClient(db, opts...).Migrate(ctx)
+}
+
+// SchemaVersion returns the latest applied migration version, or 0 before the first migration.
+func SchemaVersion(ctx context.Context, db *sql.DB, opts ...ClientOption) (int, error) {
+	return NewClient(db, opts...).SchemaVersion(ctx)
+}
+
+// Migrate applies pending migrations of the job table in a transaction.
+// Concurrent calls from other processes wait for the advisory lock, then find nothing to apply.
+func (c *Client) Migrate(ctx context.Context) error {
+	ms, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('pqueue_migrate'))`); err != nil {
+		return err
+	}
+	if c.schema != "" {
+		if _, err = tx.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS `+pq.QuoteIdentifier(c.schema)); err != nil {
+			return err
+		}
+	}
+	// Versions are recorded per job table, so several queues can live in one schema.
+	if _, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+c.qualify(migrationsTable)+` (job_table text NOT NULL, version integer NOT NULL, applied_at timestamp with time zone NOT NULL DEFAULT now(), PRIMARY KEY (job_table, version))`); err != nil {
+		return err
+	}
+	current, err := c.schemaVersion(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if m.version <= current {
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, c.stmt(m.sql)); err != nil {
+			return fmt.Errorf("pqueue: migration %s: %v", m.name, err)
+		}
+		if _, err = tx.ExecContext(ctx, `INSERT INTO `+c.qualify(migrationsTable)+` (job_table, version) VALUES ($1, $2)`, c.table, m.version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the latest applied migration version, or 0 before the first migration.
+func (c *Client) SchemaVersion(ctx context.Context) (int, error) {
+	var exists bool
+	err := c.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, c.qualify(migrationsTable)).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return c.schemaVersion(ctx, c.db)
+}
+
+func (c *Client) schemaVersion(ctx context.Context, q Querier) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, `SELECT coalesce(max(version), 0) FROM `+c.qualify(migrationsTable)+` WHERE job_table = $1`, c.table).Scan(&version)
+	return version, err
+}
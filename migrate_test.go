@@ -0,0 +1,52 @@
+package pqueue
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	ms, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 {
+		t.Fatal("migrations should be embedded")
+	}
+	for i, m := range ms {
+		if m.version != i+1 {
+			t.Errorf("migration %s expect version %d, actual %d", m.name, i+1, m.version)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := loadMigrations()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Migrate(ctx, DefaultClient().DB())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	version, err := SchemaVersion(ctx, DefaultClient().DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != ms[len(ms)-1].version {
+		t.Errorf("expect version %d, actual %d", ms[len(ms)-1].version, version)
+	}
+}
@@ -0,0 +1,110 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownJob is returned by a Mux for a job without handler.
+// A dispatcher fails such jobs without retrying.
+var ErrUnknownJob = errors.New("pqueue: no handler for job")
+
+// HandlerFunc processes a job.
+type HandlerFunc func(ctx context.Context, job Job) error
+
+// HandlerOption configures a handler registered on a Mux.
+type HandlerOption func(*handler)
+
+// HandlerTimeout limits the run time of a handler. The timeout of a job still applies.
+func HandlerTimeout(d time.Duration) HandlerOption {
+	return func(h *handler) {
+		h.timeout = d
+	}
+}
+
+// HandlerRetryPolicy sets the retry policy of jobs of a handler.
+// A policy selected by the job with WithRetry takes precedence.
+func HandlerRetryPolicy(p RetryPolicy) HandlerOption {
+	return func(h *handler) {
+		h.retry = p
+	}
+}
+
+type handler struct {
+	fn      HandlerFunc
+	timeout time.Duration
+	retry   RetryPolicy
+}
+
+// Mux is a Worker which routes jobs to handlers by job name.
+// A dispatcher of a Mux locks only jobs whose names have handlers.
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[string]handler
+}
+
+// NewMux creates an empty Mux.
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[string]handler)}
+}
+
+// Handle registers the handler for a job name.
+// It panics if the name is empty or already registered.
+func (m *Mux) Handle(name string, fn HandlerFunc, opts ...HandlerOption) {
+	if name == "" {
+		panic("pqueue: empty job name")
+	}
+	if fn == nil {
+		panic("pqueue: nil handler for " + name)
+	}
+	h := handler{fn: fn}
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.handlers[name]; ok {
+		panic("pqueue: multiple registrations for " + name)
+	}
+	m.handlers[name] = h
+}
+
+// Names returns the registered job names in order.
+func (m *Mux) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.handlers))
+	for name := range m.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Mux) retryPolicyOf(name string) RetryPolicy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.handlers[name].retry
+}
+
+// Run runs the handler of a job, or returns ErrUnknownJob.
+func (m *Mux) Run(ctx context.Context, job Job) error {
+	m.mu.RLock()
+	h, ok := m.handlers[job.Name]
+	m.mu.RUnlock()
+	if !ok {
+		return Permanent(fmt.Errorf("%w: %s", ErrUnknownJob, job.Name))
+	}
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	return h.fn(ctx, job)
+}
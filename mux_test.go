@@ -0,0 +1,113 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMuxRun(t *testing.T) {
+	m := NewMux()
+	var ran string
+	m.Handle("mail", func(ctx context.Context, job Job) error {
+		ran = job.Name
+		return nil
+	})
+
+	if err := m.Run(context.Background(), Job{Name: "mail"}); err != nil {
+		t.Error(err)
+	}
+	if ran != "mail" {
+		t.Errorf("expect mail handler, actual %s", ran)
+	}
+
+	err := m.Run(context.Background(), Job{Name: "report"})
+	if !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("expect ErrUnknownJob, actual %v", err)
+	}
+}
+
+func TestMuxNames(t *testing.T) {
+	m := NewMux()
+	noop := func(ctx context.Context, job Job) error { return nil }
+	m.Handle("report", noop)
+	m.Handle("mail", noop)
+
+	names := m.Names()
+	if len(names) != 2 || names[0] != "mail" || names[1] != "report" {
+		t.Errorf("expect [mail report], actual %v", names)
+	}
+}
+
+func TestMuxHandleTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Handle should panic on multiple registrations")
+		}
+	}()
+	m := NewMux()
+	noop := func(ctx context.Context, job Job) error { return nil }
+	m.Handle("mail", noop)
+	m.Handle("mail", noop)
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	m := NewMux()
+	m.Handle("mail", func(ctx context.Context, job Job) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, HandlerTimeout(10*time.Millisecond))
+
+	err := m.Run(context.Background(), Job{Name: "mail"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, actual %v", err)
+	}
+}
+
+func TestDispatcherLocksRegisteredNames(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("mail", nil, 5)
+	j.Save()
+	j = NewJob("report", nil, 5)
+	j.Save()
+
+	m := NewMux()
+	m.Handle("mail", func(ctx context.Context, job Job) error { return nil })
+	d := NewDispatcher(2, m)
+	d.Start(50)
+	time.Sleep(200 * time.Millisecond)
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := ProcessedJobs(time.Time{}, 0)
+	if len(jobs) != 1 || jobs[0].Name != "mail" {
+		t.Errorf("only mail job should be processed, actual %v", jobs)
+	}
+	jobs, _ = LockJobs(2)
+	if len(jobs) != 1 || jobs[0].Name != "report" {
+		t.Errorf("report job should be left, actual %v", jobs)
+	}
+}
+
+func TestDispatcherFailsUnknownJobs(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("report", nil, 5)
+	j.Save()
+
+	jobs, _ := LockJobs(1)
+	d := NewDispatcher(1, NewMux())
+	job := jobs[0]
+	d.record(&job, d.worker.Run(context.Background(), job))
+
+	jobs, _ = FailedJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Fatalf("failed jobs expect 1, actual %d", len(jobs))
+	}
+	if jobs[0].RunCount != 1 {
+		t.Errorf("unknown jobs should not be retried, run count %d", jobs[0].RunCount)
+	}
+}
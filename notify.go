@@ -0,0 +1,57 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxChannelLen is the limit of identifiers, which channel names are.
+const maxChannelLen = 63
+
+// WithDSN sets the data source name which dispatchers listen for new jobs with.
+// Clients created by Open have it already.
+func WithDSN(dsn string) ClientOption {
+	return func(c *Client) {
+		c.dsn = dsn
+	}
+}
+
+// channel returns the name of the notification channel of the job table.
+func (c *Client) channel() string {
+	name := "pqueue_" + c.table
+	if c.schema != "" {
+		name = "pqueue_" + c.schema + "_" + c.table
+	}
+	if len(name) > maxChannelLen {
+		name = name[:maxChannelLen]
+	}
+	return name
+}
+
+// notify wakes listening dispatchers of the queue. Within a transaction,
+// the notification is delivered when it commits.
+func (c *Client) notify(ctx context.Context, q Querier, queue string) error {
+	return q.QueryRowContext(ctx, `SELECT pg_notify($1, $2)`, c.channel(), queue).Scan(new(interface{}))
+}
+
+// listen opens a connection listening on the notification channel of the client.
+// The listener reconnects by itself, and sends nil on Notify after reconnecting.
+func (c *Client) listen() (*pq.Listener, error) {
+	if c.dsn == "" {
+		return nil, errors.New("pqueue: listening needs the data source name of the client")
+	}
+	l := pq.NewListener(c.dsn, 100*time.Millisecond, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			c.logger.Log(context.Background(), slog.LevelWarn, "Listener connection failed", slog.Any("error", err))
+		}
+	})
+	if err := l.Listen(c.channel()); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
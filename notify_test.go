@@ -0,0 +1,63 @@
+package pqueue
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChannel(t *testing.T) {
+	c := NewClient(nil, WithSchema("queue"), WithTable("emails"))
+	if c.channel() != "pqueue_queue_emails" {
+		t.Errorf("expect channel pqueue_queue_emails, actual %s", c.channel())
+	}
+	c = NewClient(nil, WithTable(strings.Repeat("a", 100)))
+	if len(c.channel()) != maxChannelLen {
+		t.Errorf("channel should be truncated to %d bytes, actual %d", maxChannelLen, len(c.channel()))
+	}
+}
+
+func TestDispatcherListen(t *testing.T) {
+	TruncateJob()
+
+	d := NewDispatcher(2, worker{}, WithListen(time.Hour))
+	d.Start(60 * 60 * 1000)
+	time.Sleep(100 * time.Millisecond)
+
+	j := NewJob("test", []byte(`{"duration": 10}`), 5)
+	if err := j.Save(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := ProcessedJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Errorf("saved job should be processed without polling, processed %d", len(jobs))
+	}
+}
+
+func TestDispatcherListenWithoutDSN(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", []byte(`{"duration": 10}`), 5)
+	j.Save()
+
+	c := NewClient(DefaultClient().DB())
+	d := c.NewDispatcher(1, worker{}, WithListen(time.Hour))
+	d.Start(50)
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d.Stop(ctx)
+
+	jobs, _ := ProcessedJobs(time.Time{}, 0)
+	if len(jobs) != 1 {
+		t.Errorf("dispatcher should fall back to polling, processed %d", len(jobs))
+	}
+}
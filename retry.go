@@ -0,0 +1,120 @@
+package pqueue
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides when a failed job runs again.
+// attempt is the number of runs so far, 1 after the first run failed.
+type RetryPolicy interface {
+	Backoff(attempt uint, err error) time.Duration
+}
+
+// RetryFunc is a RetryPolicy of a function.
+type RetryFunc func(attempt uint, err error) time.Duration
+
+// Backoff calls f.
+func (f RetryFunc) Backoff(attempt uint, err error) time.Duration {
+	return f(attempt, err)
+}
+
+// ExponentialBackoff doubles the delay from base on each attempt up to max.
+// The delay is jittered between half of it and the whole, so failed jobs do not retry in lockstep.
+func ExponentialBackoff(base, max time.Duration) RetryPolicy {
+	return RetryFunc(func(attempt uint, err error) time.Duration {
+		d := max
+		if attempt < 32 {
+			if n := base << (attempt - 1); n > 0 && n < max {
+				d = n
+			}
+		}
+		if d <= 0 {
+			return 0
+		}
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	})
+}
+
+// FixedBackoff retries after the same delay on every attempt.
+func FixedBackoff(d time.Duration) RetryPolicy {
+	return RetryFunc(func(attempt uint, err error) time.Duration {
+		return d
+	})
+}
+
+// LinearBackoff increases the delay by step on each attempt up to max.
+func LinearBackoff(step, max time.Duration) RetryPolicy {
+	return RetryFunc(func(attempt uint, err error) time.Duration {
+		d := step * time.Duration(attempt)
+		if d > max || d < 0 {
+			return max
+		}
+		return d
+	})
+}
+
+// WithRetryPolicy sets the retry policy of every job of a client.
+// Without it, a job retries after run count^4 + timeout + retry delay + 15 seconds.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = p
+	}
+}
+
+// WithJobRetryPolicy sets the retry policy of jobs of the name.
+func WithJobRetryPolicy(name string, p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.jobRetryPolicies[name] = p
+	}
+}
+
+// WithNamedRetryPolicy registers a retry policy, which jobs select by WithRetry.
+func WithNamedRetryPolicy(name string, p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicies[name] = p
+	}
+}
+
+// WithRetry selects the retry policy registered by WithNamedRetryPolicy for a job.
+// The name is stored with the job, so register it on every client running the job.
+func WithRetry(policy string) JobOption {
+	return func(j *Job) {
+		j.RetryPolicy = policy
+	}
+}
+
+// WithMaxAttempts sets how many times a job runs before it fails. The default is JobConfig.MaxRetryCount.
+func WithMaxAttempts(n uint) JobOption {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// maxAttempts returns the max attempts of a job. Jobs saved before max attempts were stored have 0.
+func maxAttempts(j *Job) uint {
+	if j.MaxAttempts == 0 {
+		return jobConfig.MaxRetryCount
+	}
+	return j.MaxAttempts
+}
+
+// policyOf returns the retry policy of a job: the policy selected by the job, the policy of its
+// handler, of its name, then of the client. nil means the default backoff.
+func (c *Client) policyOf(j *Job, handler RetryPolicy) RetryPolicy {
+	if p, ok := c.retryPolicies[j.RetryPolicy]; ok && j.RetryPolicy != "" {
+		return p
+	}
+	if handler != nil {
+		return handler
+	}
+	if p, ok := c.jobRetryPolicies[j.Name]; ok {
+		return p
+	}
+	return c.retryPolicy
+}
+
+// retryPolicer is implemented by workers which have retry policies per job name, such as Mux.
+type retryPolicer interface {
+	retryPolicyOf(name string) RetryPolicy
+}
@@ -0,0 +1,132 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	p := ExponentialBackoff(time.Second, time.Minute)
+	for attempt, max := range map[uint]time.Duration{1: time.Second, 3: 4 * time.Second, 10: time.Minute, 100: time.Minute} {
+		d := p.Backoff(attempt, nil)
+		if d < max/2 || d > max {
+			t.Errorf("attempt %d expect between %s and %s, actual %s", attempt, max/2, max, d)
+		}
+	}
+}
+
+func TestLinearAndFixedBackoff(t *testing.T) {
+	p := LinearBackoff(10*time.Second, 25*time.Second)
+	if d := p.Backoff(2, nil); d != 20*time.Second {
+		t.Errorf("expect 20s, actual %s", d)
+	}
+	if d := p.Backoff(3, nil); d != 25*time.Second {
+		t.Errorf("expect 25s, actual %s", d)
+	}
+	if d := FixedBackoff(time.Second).Backoff(7, nil); d != time.Second {
+		t.Errorf("expect 1s, actual %s", d)
+	}
+}
+
+func TestPolicyOf(t *testing.T) {
+	global := FixedBackoff(1)
+	byName := FixedBackoff(2)
+	named := FixedBackoff(3)
+	handler := FixedBackoff(4)
+	c := NewClient(nil,
+		WithRetryPolicy(global),
+		WithJobRetryPolicy("mail", byName),
+		WithNamedRetryPolicy("fast", named),
+	)
+
+	tests := []struct {
+		job     Job
+		handler RetryPolicy
+		expect  time.Duration
+	}{
+		{NewJob("report", nil, 5), nil, 1},
+		{NewJob("mail", nil, 5), nil, 2},
+		{NewJob("mail", nil, 5), handler, 4},
+		{NewJob("mail", nil, 5, WithRetry("fast")), handler, 3},
+		{NewJob("mail", nil, 5, WithRetry("unknown")), nil, 2},
+	}
+	for _, tt := range tests {
+		if d := c.policyOf(&tt.job, tt.handler).Backoff(1, nil); d != tt.expect {
+			t.Errorf("job %s with %q expect %d, actual %d", tt.job.Name, tt.job.RetryPolicy, tt.expect, d)
+		}
+	}
+}
+
+func TestFailWithRetryPolicy(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	c := NewClient(DefaultClient().DB(), WithNamedRetryPolicy("fast", FixedBackoff(time.Minute)))
+	j := NewJob("test", nil, 5, WithRetry("fast"), WithMaxAttempts(2))
+	if err := c.Save(ctx, &j); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := c.LockJobs(ctx, 1)
+	if len(jobs) != 1 || jobs[0].MaxAttempts != 2 || jobs[0].RetryPolicy != "fast" {
+		t.Fatalf("locked job should have the retry settings, %v", jobs)
+	}
+	job := jobs[0]
+	start := time.Now()
+	if err := c.fail(ctx, &job, errors.New("boom"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusPending {
+		t.Error("job should be retried")
+	}
+	if job.RunAfter.Before(start.Add(time.Minute)) || job.RunAfter.After(time.Now().Add(time.Minute)) {
+		t.Errorf("job should run after a minute, actual %s", job.RunAfter)
+	}
+	if job.RetryDelay != 60 {
+		t.Errorf("expect retry delay 60, actual %d", job.RetryDelay)
+	}
+
+	if err := c.fail(ctx, &job, errors.New("boom"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusFailed {
+		t.Error("job should fail after max attempts")
+	}
+}
+
+func TestFailWithLongBackoff(t *testing.T) {
+	TruncateJob()
+	ctx := context.Background()
+
+	c := NewClient(DefaultClient().DB(), WithNamedRetryPolicy("daily", FixedBackoff(24*time.Hour)))
+	daily := NewJob("test", nil, 5, WithRetry("daily"))
+	late := NewJob("test", nil, 5, WithMaxAttempts(100))
+	late.RunCount = 40
+	for _, j := range []*Job{&daily, &late} {
+		if err := c.Save(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, _ := c.LockJobs(ctx, 2)
+	if len(jobs) != 2 {
+		t.Fatalf("locked jobs expect 2, actual %d", len(jobs))
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if err := c.fail(ctx, job, errors.New("boom"), nil); err != nil {
+			t.Fatal(err)
+		}
+		// retry_delay would overflow a smallint column.
+		var delay uint
+		c.DB().QueryRow(`SELECT retry_delay FROM job WHERE id = $1`, job.ID).Scan(&delay)
+		if delay != job.RetryDelay || delay <= 32767 {
+			t.Errorf("job %d expect retry delay %d, actual %d", job.ID, job.RetryDelay, delay)
+		}
+		if job.ID == daily.ID && job.RetryDelay != 86400 {
+			t.Errorf("expect retry delay 86400, actual %d", job.RetryDelay)
+		}
+	}
+}
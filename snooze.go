@@ -0,0 +1,64 @@
+package pqueue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// snoozeError is the error returned by Snooze.
+type snoozeError struct {
+	d time.Duration
+}
+
+func (e *snoozeError) Error() string {
+	return fmt.Sprintf("pqueue: snoozed for %s", e.d)
+}
+
+// Snooze returns an error which makes the dispatcher run the job again after d, e.g. when
+// a dependency is not ready yet. It does not count as an attempt, but as a snooze in SnoozeCount.
+func Snooze(d time.Duration) error {
+	return &snoozeError{d: d}
+}
+
+// snoozed returns the delay of a snooze error.
+func snoozed(err error) (time.Duration, bool) {
+	var s *snoozeError
+	if errors.As(err, &s) {
+		return s.d, true
+	}
+	return 0, false
+}
+
+// Snooze re-queues a job to run after d without counting an attempt. The run is recorded in
+// the attempts of the job as snoozed. It returns ErrLeaseExpired like Complete.
+func (c *Client) Snooze(ctx context.Context, j *Job, d time.Duration) error {
+	runAfter := time.Now().Add(d)
+	// A job cancelled while running is not re-queued.
+	err := c.db.QueryRowContext(ctx, c.stmt(`WITH updated AS (UPDATE {job} SET run_after = $2, elapsed = $3, snooze_count = snooze_count + 1, locked_at = null, locked_by = null, status = CASE WHEN cancelled_at IS NULL THEN 0 ELSE 3 END WHERE id = $1`+heldBy("$4")+` RETURNING id, run_count, status, snooze_count), `+
+		`attempt AS (`+attemptSQL(AttemptSnoozed, "$4", "$5", "$3", "$6")+`) SELECT status, snooze_count FROM updated`), j.ID, runAfter, j.Elapsed, j.LockedBy, startedAt(j), Snooze(d).Error()).Scan(&j.Status, &j.SnoozeCount)
+	if err == sql.ErrNoRows {
+		return ErrLeaseExpired
+	}
+	if err != nil {
+		return err
+	}
+	j.RunAfter = runAfter
+	j.LockedBy = ""
+
+	c.logger.Log(ctx, slog.LevelInfo, "Snoozed job", append(c.jobAttrs(j), slog.Duration("snooze", d))...)
+	return nil
+}
+
+// Snooze re-queues a job to run after d without counting an attempt using the default client.
+func (j *Job) Snooze(d time.Duration) error {
+	return j.SnoozeContext(context.Background(), d)
+}
+
+// SnoozeContext re-queues a job to run after d without counting an attempt using the default client.
+func (j *Job) SnoozeContext(ctx context.Context, d time.Duration) error {
+	return defaultClient.Snooze(ctx, j, d)
+}
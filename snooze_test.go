@@ -0,0 +1,60 @@
+package pqueue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnoozed(t *testing.T) {
+	d, ok := snoozed(fmt.Errorf("wait: %w", Snooze(time.Minute)))
+	if !ok || d != time.Minute {
+		t.Errorf("expect snooze of 1m, actual %s %v", d, ok)
+	}
+	if _, ok = snoozed(fmt.Errorf("boom")); ok {
+		t.Error("plain errors should not snooze")
+	}
+}
+
+type snoozingWorker struct{}
+
+func (w snoozingWorker) Run(ctx context.Context, job Job) error {
+	return Snooze(time.Hour)
+}
+
+func TestSnoozeJob(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 5)
+	j.Save()
+
+	before := time.Now()
+	d := NewDispatcher(1, snoozingWorker{})
+	d.Start(50)
+	time.Sleep(110 * time.Millisecond)
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	d.Stop(ctx)
+
+	jobs, _ := EnqueuedJobsByName("test")
+	if len(jobs) != 1 {
+		t.Fatalf("snoozed job should be enqueued, actual %d jobs", len(jobs))
+	}
+	if jobs[0].RunAfter.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("snoozed job should run after an hour, actual %s", jobs[0].RunAfter)
+	}
+
+	jobs, _ = FindJobs(ctx, JobFilter{Names: []string{"test"}})
+	if len(jobs) != 1 || jobs[0].RunCount != 0 || jobs[0].SnoozeCount != 1 || jobs[0].Status != StatusPending {
+		t.Errorf("snooze should not count an attempt, %+v", jobs)
+	}
+
+	attempts, _ := JobAttempts(context.Background(), j.ID)
+	if len(attempts) != 1 || attempts[0].Outcome != AttemptSnoozed || attempts[0].Attempt != 0 || attempts[0].WorkerID != d.lockID {
+		t.Fatalf("snoozed run should be recorded, %+v", attempts)
+	}
+	if a := attempts[0]; a.StartedAt.Before(before) || a.FinishedAt.Before(a.StartedAt) {
+		t.Errorf("snoozed run should have its start time, %+v", a)
+	}
+}
@@ -0,0 +1,81 @@
+package pqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// defaultTimeout is the timeout in seconds of jobs enqueued by Enqueue without WithTimeout.
+const defaultTimeout = 60
+
+// JobMeta describes the job given to a typed handler.
+type JobMeta struct {
+	ID       int64
+	Name     string
+	Queue    string
+	Codec    string
+	Priority int
+	RunAfter time.Time
+	RunCount uint
+	// SnoozeCount is the number of runs which returned Snooze.
+	SnoozeCount uint
+	// Payload is the raw payload before decoding.
+	Payload json.RawMessage
+}
+
+func newJobMeta(j Job) JobMeta {
+	return JobMeta{
+		ID:          j.ID,
+		Name:        j.Name,
+		Queue:       j.Queue,
+		Codec:       j.Codec,
+		Priority:    j.Priority,
+		RunAfter:    j.RunAfter,
+		RunCount:    j.RunCount,
+		SnoozeCount: j.SnoozeCount,
+		Payload:     j.Payload,
+	}
+}
+
+// Handle registers a handler on m which receives the payload decoded into T
+// by the codec of the job. A payload which cannot be decoded fails the job without retrying.
+func Handle[T any](m *Mux, name string, fn func(ctx context.Context, payload T, meta JobMeta) error, opts ...HandlerOption) {
+	m.Handle(name, func(ctx context.Context, job Job) error {
+		var payload T
+		if len(job.Payload) > 0 {
+			codec, err := decoder(ctx, job.Codec)
+			if err != nil {
+				return Permanent(err)
+			}
+			if err = codec.Unmarshal(job.Payload, &payload); err != nil {
+				return Permanent(fmt.Errorf("pqueue: decode payload of %s: %w", job.Name, err))
+			}
+		}
+		return fn(ctx, payload, newJobMeta(job))
+	}, opts...)
+}
+
+// Enqueue encodes a payload and inserts a job using the default client.
+func Enqueue[T any](ctx context.Context, name string, payload T, opts ...JobOption) (Job, error) {
+	return EnqueueWith(ctx, defaultClient, name, payload, opts...)
+}
+
+// EnqueueWith encodes a payload with the codec of the job name and inserts a job using c.
+// The job times out in 60 seconds unless WithTimeout is given.
+func EnqueueWith[T any](ctx context.Context, c *Client, name string, payload T, opts ...JobOption) (Job, error) {
+	j := NewJob(name, nil, defaultTimeout, opts...)
+	if j.Codec == "" {
+		j.Codec = c.codecName(name)
+	}
+	codec, err := c.codec(j.Codec)
+	if err != nil {
+		return Job{}, err
+	}
+	if j.Payload, err = codec.Marshal(payload); err != nil {
+		return Job{}, err
+	}
+	err = c.Save(ctx, &j)
+	return j, err
+}
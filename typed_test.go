@@ -0,0 +1,95 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type mailPayload struct {
+	To string `json:"to"`
+}
+
+func TestHandleDecodesPayload(t *testing.T) {
+	m := NewMux()
+	var got mailPayload
+	var meta JobMeta
+	Handle(m, "mail", func(ctx context.Context, p mailPayload, jm JobMeta) error {
+		got = p
+		meta = jm
+		return nil
+	})
+
+	err := m.Run(context.Background(), Job{ID: 3, Name: "mail", Payload: []byte(`{"to":"someone@example.com"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.To != "someone@example.com" {
+		t.Errorf("expect decoded payload, actual %+v", got)
+	}
+	if meta.ID != 3 || string(meta.Payload) != `{"to":"someone@example.com"}` {
+		t.Errorf("expect job meta with raw payload, actual %+v", meta)
+	}
+}
+
+func TestHandleDecodeErrorIsPermanent(t *testing.T) {
+	m := NewMux()
+	Handle(m, "mail", func(ctx context.Context, p mailPayload, meta JobMeta) error {
+		t.Error("handler should not run")
+		return nil
+	})
+
+	err := m.Run(context.Background(), Job{Name: "mail", Payload: []byte(`{"to":1}`)})
+	if err == nil || !IsPermanent(err) {
+		t.Errorf("expect permanent error, actual %v", err)
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	m := NewMux()
+	errSend := errors.New("send")
+	Handle(m, "mail", func(ctx context.Context, p mailPayload, meta JobMeta) error {
+		return errSend
+	})
+
+	err := m.Run(context.Background(), Job{Name: "mail"})
+	if err != errSend || IsPermanent(err) {
+		t.Errorf("expect handler error, actual %v", err)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	TruncateJob()
+
+	job, err := Enqueue(context.Background(), "mail", mailPayload{To: "someone@example.com"}, InQueue("mail"), WithPriority(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == 0 || job.Timeout != defaultTimeout {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	m := NewMux()
+	done := make(chan mailPayload, 1)
+	Handle(m, "mail", func(ctx context.Context, p mailPayload, meta JobMeta) error {
+		done <- p
+		return nil
+	})
+	d := NewDispatcher(1, m)
+	d.Start(50)
+	defer func() {
+		ctx, c := context.WithTimeout(context.Background(), time.Second)
+		defer c()
+		d.Stop(ctx)
+	}()
+
+	select {
+	case p := <-done:
+		if p.To != "someone@example.com" {
+			t.Errorf("expect decoded payload, actual %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler should run")
+	}
+}
@@ -0,0 +1,121 @@
+package pqueue
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateJob is matched by errors.Is when a pending or running job has the same unique key.
+var ErrDuplicateJob = errors.New("pqueue: duplicate job")
+
+// DuplicateJobError is returned by Save when a pending or running job has the same unique key.
+type DuplicateJobError struct {
+	UniqueKey string
+	// ID of the existing job, or 0 if unknown.
+	ID int64
+}
+
+func (e *DuplicateJobError) Error() string {
+	return fmt.Sprintf("pqueue: duplicate job of unique key %s, id: %d", e.UniqueKey, e.ID)
+}
+
+// Is reports whether target is ErrDuplicateJob.
+func (e *DuplicateJobError) Is(target error) bool {
+	return target == ErrDuplicateJob
+}
+
+// DuplicateAction is what Save does when a pending or running job has the same unique key.
+type DuplicateAction int
+
+const (
+	// ErrorOnDuplicate returns *DuplicateJobError. It is the default.
+	ErrorOnDuplicate DuplicateAction = iota
+	// ReturnDuplicate sets the job to the existing one.
+	ReturnDuplicate
+	// ReplaceDuplicate replaces the existing job unless it is running.
+	// A running job is reported as *DuplicateJobError.
+	ReplaceDuplicate
+)
+
+// WithUniqueKey prevents enqueuing the job while another job of the key is pending or running.
+func WithUniqueKey(key string) JobOption {
+	return func(j *Job) {
+		j.UniqueKey = key
+	}
+}
+
+// UniqueByPayload derives the unique key of the job from a hash of its name and payload.
+func UniqueByPayload() JobOption {
+	return func(j *Job) {
+		j.uniqueByPayload = true
+	}
+}
+
+// OnDuplicate sets what Save does for a duplicate of the job.
+func OnDuplicate(a DuplicateAction) JobOption {
+	return func(j *Job) {
+		j.onDuplicate = a
+	}
+}
+
+func payloadKey(j *Job) string {
+	h := sha256.New()
+	h.Write([]byte(j.Name))
+	h.Write([]byte{0})
+	h.Write(j.Payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// uniqueConflict matches the partial unique index of unique keys.
+const uniqueConflict = ` ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND status = 0`
+
+// saveUnique inserts a job with a unique key without aborting the transaction of q on duplicates.
+func (c *Client) saveUnique(ctx context.Context, q Querier, j *Job, args []interface{}) error {
+	query := insertJobSQL + uniqueConflict + ` DO NOTHING RETURNING id`
+	if j.onDuplicate == ReplaceDuplicate {
+		query = insertJobSQL + uniqueConflict + ` DO UPDATE SET name = EXCLUDED.name, queue = EXCLUDED.queue, codec = EXCLUDED.codec, payload = EXCLUDED.payload, raw_payload = EXCLUDED.raw_payload, priority = EXCLUDED.priority, run_after = EXCLUDED.run_after, timeout = EXCLUDED.timeout, retry_delay = EXCLUDED.retry_delay, max_attempts = EXCLUDED.max_attempts, retry_policy = EXCLUDED.retry_policy WHERE {job}.locked_at IS NULL RETURNING id`
+	}
+
+	// The existing job may finish between the INSERT and the SELECT, then the INSERT is tried again.
+	for i := 0; i < 3; i++ {
+		err := q.QueryRowContext(ctx, c.stmt(query), args...).Scan(&j.ID)
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		existing, err := scanJob(q.QueryRowContext(ctx, c.stmt(`SELECT `+jobColumns+` FROM {job} WHERE unique_key = $1 AND status = 0`), j.UniqueKey))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if j.onDuplicate == ReturnDuplicate {
+			*j = existing
+			return nil
+		}
+		return &DuplicateJobError{UniqueKey: j.UniqueKey, ID: existing.ID}
+	}
+	return &DuplicateJobError{UniqueKey: j.UniqueKey}
+}
+
+// duplicateError converts a unique violation of a multi-row INSERT.
+func (c *Client) duplicateError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == c.table+"_unique_key" {
+		// Detail is like: Key (unique_key)=(sync account 42) already exists.
+		key := pqErr.Detail
+		if i := strings.Index(key, ")=("); i >= 0 {
+			key = strings.TrimSuffix(key[i+3:], ") already exists.")
+		}
+		return &DuplicateJobError{UniqueKey: key}
+	}
+	return err
+}
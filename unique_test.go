@@ -0,0 +1,133 @@
+package pqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestUniqueByPayload(t *testing.T) {
+	a := NewJob("sync account", []byte(`{"id":1}`), 5, UniqueByPayload())
+	b := NewJob("sync account", []byte(`{"id":2}`), 5, UniqueByPayload())
+	if payloadKey(&a) == payloadKey(&b) {
+		t.Error("keys of different payloads should differ")
+	}
+	c := NewJob("sync account", []byte(`{"id":1}`), 5)
+	if payloadKey(&a) != payloadKey(&c) {
+		t.Error("keys of the same name and payload should be equal")
+	}
+}
+
+func TestSaveDuplicateJob(t *testing.T) {
+	TruncateJob()
+
+	first := NewJob("sync account", []byte(`{"id":1}`), 5, UniqueByPayload())
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := NewJob("sync account", []byte(`{"id":1}`), 5, UniqueByPayload())
+	err := dup.Save()
+	if !errors.Is(err, ErrDuplicateJob) {
+		t.Fatalf("expect ErrDuplicateJob, actual %v", err)
+	}
+	var dupErr *DuplicateJobError
+	if !errors.As(err, &dupErr) || dupErr.ID != first.ID {
+		t.Errorf("expect the existing job id %d, actual %v", first.ID, err)
+	}
+
+	dup = NewJob("sync account", []byte(`{"id":1}`), 5, UniqueByPayload(), OnDuplicate(ReturnDuplicate))
+	if err = dup.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if dup.ID != first.ID {
+		t.Errorf("expect the existing job id %d, actual %d", first.ID, dup.ID)
+	}
+
+	first.Complete()
+	again := NewJob("sync account", []byte(`{"id":1}`), 5, UniqueByPayload())
+	if err = again.Save(); err != nil {
+		t.Errorf("finished jobs should not be duplicates, %v", err)
+	}
+}
+
+func TestSaveReplaceDuplicateJob(t *testing.T) {
+	TruncateJob()
+
+	first := NewJob("sync account", []byte(`{"v":1}`), 5, WithUniqueKey("account-1"))
+	first.Save()
+
+	replace := NewJob("sync account", []byte(`{"v":2}`), 5, WithUniqueKey("account-1"), WithPriority(3), OnDuplicate(ReplaceDuplicate))
+	if err := replace.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if replace.ID != first.ID {
+		t.Errorf("expect the existing job id %d, actual %d", first.ID, replace.ID)
+	}
+	jobs, _ := FindJobs(context.Background(), JobFilter{})
+	if len(jobs) != 1 || jobs[0].Priority != 3 || string(jobs[0].Payload) != `{"v": 2}` {
+		t.Errorf("expect the replaced job, actual %+v", jobs)
+	}
+
+	LockJobs(1)
+	replace = NewJob("sync account", []byte(`{"v":3}`), 5, WithUniqueKey("account-1"), OnDuplicate(ReplaceDuplicate))
+	if err := replace.Save(); !errors.Is(err, ErrDuplicateJob) {
+		t.Errorf("running jobs should not be replaced, %v", err)
+	}
+}
+
+func TestSaveDuplicateJobInTx(t *testing.T) {
+	TruncateJob()
+
+	ctx := context.Background()
+	first := NewJob("sync account", nil, 5, WithUniqueKey("account-1"))
+	first.Save()
+
+	tx, err := DefaultClient().DB().BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	dup := NewJob("sync account", nil, 5, WithUniqueKey("account-1"))
+	if err = dup.SaveTx(ctx, tx); !errors.Is(err, ErrDuplicateJob) {
+		t.Fatalf("expect ErrDuplicateJob, actual %v", err)
+	}
+	other := NewJob("sync account", nil, 5, WithUniqueKey("account-2"))
+	if err = other.SaveTx(ctx, tx); err != nil {
+		t.Errorf("transaction should be usable after a duplicate, %v", err)
+	}
+}
+
+func TestEnqueueManyDuplicateJob(t *testing.T) {
+	TruncateJob()
+
+	jobs := []Job{
+		NewJob("sync account", nil, 5, WithUniqueKey("account-1")),
+		NewJob("sync account", nil, 5, WithUniqueKey("account-1")),
+	}
+	_, err := EnqueueMany(context.Background(), jobs)
+	var dupErr *DuplicateJobError
+	if !errors.As(err, &dupErr) || dupErr.UniqueKey != "account-1" {
+		t.Errorf("expect duplicate of account-1, actual %v", err)
+	}
+}
+
+func TestEnqueueManyReturnDuplicate(t *testing.T) {
+	TruncateJob()
+
+	jobs := []Job{
+		NewJob("sync account", nil, 5, WithUniqueKey("account-1"), OnDuplicate(ReturnDuplicate)),
+		NewJob("test", nil, 5),
+		NewJob("sync account", nil, 5, WithUniqueKey("account-1"), OnDuplicate(ReturnDuplicate)),
+	}
+	ids, err := EnqueueMany(context.Background(), jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != ids[2] || ids[0] == ids[1] {
+		t.Errorf("duplicate should return the existing job, ids %v", ids)
+	}
+	if jobs[2].ID != ids[0] {
+		t.Errorf("expect job id %d, actual %d", ids[0], jobs[2].ID)
+	}
+}
@@ -0,0 +1,131 @@
+package pqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const workersTable = "pqueue_workers"
+
+// workerExpiry is how long a worker which stopped heartbeating stays listed.
+const workerExpiry = 24 * time.Hour
+
+// WorkerInfo describes a registered dispatcher.
+type WorkerInfo struct {
+	ID          string        `json:"id"` // locked_by of jobs locked by the dispatcher
+	Hostname    string        `json:"hostname"`
+	PID         int           `json:"pid"`
+	Queues      []string      `json:"queues"` // empty for every queue
+	Concurrency int           `json:"concurrency"`
+	Version     string        `json:"version"`
+	LeaseTTL    time.Duration `json:"lease_ttl"`
+	StartedAt   time.Time     `json:"started_at"`
+	LastSeen    time.Time     `json:"last_seen"`
+	Jobs        []int64       `json:"jobs"` // ids of locked jobs
+}
+
+// WithVersion sets the version a dispatcher registers with.
+// The default is the version of the main module of the binary.
+func WithVersion(version string) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.version = version
+	}
+}
+
+// buildVersion returns the version of the main module of the binary, if known.
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return ""
+}
+
+// ListWorkers returns the registered dispatchers of the job table, with the jobs they lock.
+// A worker whose LastSeen is older than its LeaseTTL is dead, and its jobs are re-queued soon.
+func (c *Client) ListWorkers(ctx context.Context) ([]WorkerInfo, error) {
+	rows, err := c.db.QueryContext(ctx, c.stmt(`SELECT w.id, w.hostname, w.pid, coalesce(w.queues, '{}'), w.concurrency, w.version, w.lease_ttl, w.started_at, w.last_seen, array(SELECT id FROM {job} WHERE locked_by = w.id AND status = 0 ORDER BY id) FROM {workers} w WHERE w.job_table = $1 ORDER BY w.started_at, w.id`), c.table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workers []WorkerInfo
+	for rows.Next() {
+		w := WorkerInfo{}
+		var queues pq.StringArray
+		var jobs pq.Int64Array
+		var leaseTTL float64
+		err := rows.Scan(
+			&w.ID,
+			&w.Hostname,
+			&w.PID,
+			&queues,
+			&w.Concurrency,
+			&w.Version,
+			&leaseTTL,
+			&w.StartedAt,
+			&w.LastSeen,
+			&jobs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		w.Queues = queues
+		w.LeaseTTL = time.Duration(leaseTTL * float64(time.Second))
+		w.Jobs = jobs
+		workers = append(workers, w)
+	}
+	return workers, rows.Err()
+}
+
+// ListWorkers returns the registered dispatchers using the default client.
+func ListWorkers(ctx context.Context) ([]WorkerInfo, error) {
+	return defaultClient.ListWorkers(ctx)
+}
+
+// register records the dispatcher in the workers table.
+func (d *Dispatcher) register() error {
+	host, _ := os.Hostname()
+	_, err := d.client.db.ExecContext(d.ctx, d.client.stmt(`INSERT INTO {workers} (id, job_table, hostname, pid, queues, concurrency, version, lease_ttl) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (id) DO UPDATE SET last_seen = now()`),
+		d.lockID,
+		d.client.table,
+		host,
+		os.Getpid(),
+		pq.StringArray(d.queues),
+		cap(d.sem),
+		d.version,
+		d.leaseTTL.Seconds(),
+	)
+	return err
+}
+
+// touch updates last_seen of the dispatcher.
+func (d *Dispatcher) touch() {
+	_, err := d.client.db.ExecContext(d.ctx, d.client.stmt(`UPDATE {workers} SET last_seen = now() WHERE id = $1`), d.lockID)
+	if err != nil {
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to update worker", slog.Any("error", err))
+	}
+}
+
+// prune removes workers which stopped heartbeating longer than workerExpiry ago.
+func (d *Dispatcher) prune() {
+	_, err := d.client.db.ExecContext(d.ctx, d.client.stmt(`DELETE FROM {workers} WHERE last_seen < now() - make_interval(secs => $1)`), workerExpiry.Seconds())
+	if err != nil {
+		d.client.logger.Log(d.ctx, slog.LevelError, "Failed to prune workers", slog.Any("error", err))
+	}
+}
+
+// deregister removes the dispatcher from the workers table.
+func (d *Dispatcher) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	_, err := d.client.db.ExecContext(ctx, d.client.stmt(`DELETE FROM {workers} WHERE id = $1`), d.lockID)
+	if err != nil {
+		d.client.logger.Log(ctx, slog.LevelError, "Failed to deregister worker", slog.Any("error", err))
+	}
+}
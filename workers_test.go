@@ -0,0 +1,60 @@
+package pqueue
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func findWorker(t *testing.T, id string) (WorkerInfo, bool) {
+	workers, err := ListWorkers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range workers {
+		if w.ID == id {
+			return w, true
+		}
+	}
+	return WorkerInfo{}, false
+}
+
+func TestListWorkers(t *testing.T) {
+	TruncateJob()
+
+	j := NewJob("test", nil, 60, InQueue("mail"))
+	j.Save()
+
+	w := blockingWorker{canceled: make(chan struct{}, 1)}
+	d := NewDispatcher(3, w, WithQueues("mail"), WithVersion("v1.2.3"), WithLease(time.Minute))
+	d.Start(50)
+	time.Sleep(110 * time.Millisecond)
+
+	info, ok := findWorker(t, d.lockID)
+	if !ok {
+		t.Fatal("dispatcher should be registered")
+	}
+	host, _ := os.Hostname()
+	if info.Hostname != host || info.PID != os.Getpid() || info.Concurrency != 3 || info.Version != "v1.2.3" || info.LeaseTTL != time.Minute {
+		t.Errorf("invalid worker %+v", info)
+	}
+	if len(info.Queues) != 1 || info.Queues[0] != "mail" {
+		t.Errorf("expect queues [mail], actual %v", info.Queues)
+	}
+	if len(info.Jobs) != 1 || info.Jobs[0] != j.ID {
+		t.Errorf("expect jobs [%d], actual %v", j.ID, info.Jobs)
+	}
+
+	jobs, _ := ProcessingJobs()
+	if len(jobs) != 1 || jobs[0].LockedBy != info.ID {
+		t.Error("running job should be locked by the worker")
+	}
+
+	ctx, c := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer c()
+	d.Stop(ctx)
+	if _, ok = findWorker(t, d.lockID); ok {
+		t.Error("stopped dispatcher should be deregistered")
+	}
+}